@@ -0,0 +1,178 @@
+// api/audiocache.go
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/cache"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/tts"
+)
+
+// Defaults for the audio cache; AUDIO_CACHE_DIR and AUDIO_CACHE_MAX_MB
+// override them
+const (
+	defaultAudioCacheDir   = "audio-cache"
+	defaultAudioCacheMaxMB = 1024
+)
+
+// audioContentTypes maps cached clips' extensions back to content types
+var audioContentTypes = map[string]string{
+	"mp3": "audio/mpeg",
+	"wav": "audio/wav",
+}
+
+// audioCache stores synthesized clips on disk by what was asked for, and
+// optionally describes them in the audio_clips table.
+type audioCache struct {
+	disk     *cache.Disk
+	postgres bool
+}
+
+// clipCache caches synthesized speech, configured by InitVoices; nil when off
+var clipCache *audioCache
+
+// loadAudioCache configures the cache from AUDIO_CACHE_DIR (default
+// "audio-cache"), AUDIO_CACHE_MAX_MB (default 1024; 0 turns caching off) and
+// AUDIO_CACHE_POSTGRES, which records clips in the audio_clips table.
+func loadAudioCache() (*audioCache, error) {
+	maxMB := defaultAudioCacheMaxMB
+	if v := os.Getenv("AUDIO_CACHE_MAX_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid AUDIO_CACHE_MAX_MB %q", v)
+		}
+		maxMB = n
+	}
+	if maxMB == 0 {
+		return nil, nil
+	}
+
+	dir := os.Getenv("AUDIO_CACHE_DIR")
+	if dir == "" {
+		dir = defaultAudioCacheDir
+	}
+	ac := &audioCache{}
+	if v := os.Getenv("AUDIO_CACHE_POSTGRES"); v != "" {
+		postgres, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUDIO_CACHE_POSTGRES %q", v)
+		}
+		ac.postgres = postgres
+	}
+
+	var onEvict func(key string)
+	if ac.postgres {
+		onEvict = func(key string) {
+			if err := db.DeleteAudioClip(key); err != nil {
+				log.Printf("Failed to remove evicted audio clip: %v", err)
+			}
+		}
+	}
+	disk, err := cache.OpenDisk(dir, int64(maxMB)<<20, onEvict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio cache: %w", err)
+	}
+	ac.disk = disk
+
+	log.Printf("Caching audio in %s (up to %d MB)", dir, maxMB)
+	return ac, nil
+}
+
+// audioCacheKey addresses a clip by everything that shapes it: the provider,
+// voice, settings, format and the text with its whitespace normalized. It
+// doubles as the clip's ETag.
+func audioCacheKey(provider string, req tts.Request) string {
+	data, _ := json.Marshal(struct {
+		Provider string
+		VoiceID  string
+		Settings tts.VoiceSettings
+		Format   string
+		Text     string
+	}{
+		Provider: provider,
+		VoiceID:  req.VoiceID,
+		Settings: req.Settings,
+		Format:   req.Format,
+		Text:     strings.Join(strings.Fields(req.Text), " "),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// synthesizeCached is provider.Synthesize behind clipCache. read and write say
+// whether the cache may answer the request and whether to store the result;
+// the returned status is one of the response cache's.
+func synthesizeCached(ctx context.Context, provider tts.Provider, req tts.Request, read, write bool) (*tts.Audio, string, error) {
+	if clipCache == nil {
+		audio, err := provider.Synthesize(ctx, req)
+		return audio, cacheBypass, err
+	}
+	return clipCache.synthesize(ctx, provider, req, read, write)
+}
+
+func (ac *audioCache) synthesize(ctx context.Context, provider tts.Provider, req tts.Request, read, write bool) (*tts.Audio, string, error) {
+	key := audioCacheKey(provider.Name(), req)
+
+	if read {
+		if data, ext, ok := ac.disk.Get(key); ok {
+			contentType, known := audioContentTypes[ext]
+			if !known {
+				contentType = "application/octet-stream"
+			}
+			if ac.postgres {
+				if err := db.TouchAudioClip(key); err != nil {
+					log.Printf("Failed to record audio cache hit: %v", err)
+				}
+			}
+			return &tts.Audio{Data: data, ContentType: contentType, Extension: ext}, cacheHit, nil
+		}
+	}
+
+	audio, err := provider.Synthesize(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	status := cacheMiss
+	if !read {
+		status = cacheBypass
+	}
+	if write {
+		ac.put(key, provider.Name(), req, audio)
+	}
+	return audio, status, nil
+}
+
+// put stores a clip. Failures are logged, since the clip itself was made.
+func (ac *audioCache) put(key, provider string, req tts.Request, audio *tts.Audio) {
+	if err := ac.disk.Put(key, audio.Extension, audio.Data); err != nil {
+		log.Printf("Failed to cache audio clip: %v", err)
+		return
+	}
+	if !ac.postgres {
+		return
+	}
+
+	settings, _ := json.Marshal(req.Settings)
+	err := db.PutAudioClip(db.AudioClip{
+		Key:         key,
+		Provider:    provider,
+		VoiceID:     req.VoiceID,
+		Settings:    settings,
+		Text:        req.Text,
+		ContentType: audio.ContentType,
+		SizeBytes:   len(audio.Data),
+	})
+	if err != nil {
+		log.Printf("Failed to record cached audio clip: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+// api/batch.go
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/batch"
+)
+
+// codeInvalidRequest marks batch lines that fail request validation
+const codeInvalidRequest = "invalid_request"
+
+// Defaults for batch runs; BATCH_MAX_CONCURRENCY and BATCH_MAX_RATE override
+// the caps
+const (
+	defaultBatchConcurrency = 2
+	defaultBatchMaxWorkers  = 4
+)
+
+// GenerateBatch runs a JSONL file of DialogueRequest objects and streams back
+// one JSONL record per input line, in the order they finish, with the line's
+// status, error code, timing and result.
+//
+// The input is the request body, or the "input" file of a multipart form.
+// A multipart form may also carry a "previous" file, the partial output of an
+// earlier run, whose succeeded lines are skipped. The "concurrency" and
+// "rate" (requests started per second) parameters may be given in the query
+// or the form.
+func GenerateBatch(c *fiber.Ctx) error {
+	input, previous, err := batchFiles(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	requests, err := batch.ReadRequests(input)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to read input: %v", err),
+		})
+	}
+	if len(requests) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Input has no requests",
+		})
+	}
+
+	opts, msg := batchOptions(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	if previous != nil {
+		if opts.Skip, err = batch.Completed(previous); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Failed to read previous output: %v", err),
+			})
+		}
+	}
+
+	c.Set("Content-Type", "application/x-ndjson")
+	c.Set("X-Accel-Buffering", "no")
+
+	endpoint, apiKey := c.Route().Path, apiKeyFingerprint(c)
+	generate := func(ctx context.Context, body json.RawMessage) (json.RawMessage, error) {
+		return generateBatchLine(ctx, body, endpoint, apiKey)
+	}
+
+	// The writer runs after the handler returns, so it must not touch c
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		err := batch.Run(context.Background(), requests, opts, generate, func(record batch.Record) error {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			log.Printf("Batch stopped early: %v", err)
+		}
+	})
+
+	return nil
+}
+
+// generateBatchLine generates the dialogue for one batch line, recording its
+// usage under the batch's endpoint and API key.
+func generateBatchLine(ctx context.Context, body json.RawMessage, endpoint, apiKey string) (json.RawMessage, error) {
+	var req DialogueRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, &batch.Error{Code: codeInvalidRequest, Err: fmt.Errorf("invalid request: %v", err)}
+	}
+	if msg := validateDialogueRequest(&req); msg != "" {
+		return nil, &batch.Error{Code: codeInvalidRequest, Err: errors.New(msg)}
+	}
+
+	dialogue, err := cachedDialogue(ctx, req, true, true)
+	if err != nil {
+		_, code, _ := generationFailure(err)
+		return nil, &batch.Error{Code: code, Err: err}
+	}
+	recordUsage(endpoint, apiKey, dialogue.Usage)
+	return json.Marshal(dialogue)
+}
+
+// batchFiles returns the batch input and, when resuming, the previous output.
+func batchFiles(c *fiber.Ctx) (input, previous io.Reader, err error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		// Not a multipart form: the body is the input
+		return bytes.NewReader(c.Body()), nil, nil
+	}
+
+	files := form.File["input"]
+	if len(files) == 0 {
+		return nil, nil, errors.New("The \"input\" file is required")
+	}
+	if input, err = readFormFile(files[0]); err != nil {
+		return nil, nil, errors.New("Failed to read the input file")
+	}
+	if files := form.File["previous"]; len(files) > 0 {
+		if previous, err = readFormFile(files[0]); err != nil {
+			return nil, nil, errors.New("Failed to read the previous output file")
+		}
+	}
+	return input, previous, nil
+}
+
+// readFormFile reads an uploaded file into memory, closing it, since the
+// batch outlives the request that uploaded it.
+func readFormFile(fh *multipart.FileHeader) (io.Reader, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// batchOptions reads the concurrency and rate parameters, capped by the
+// server's limits.
+func batchOptions(c *fiber.Ctx) (batch.Options, string) {
+	maxWorkers, maxRate := defaultBatchMaxWorkers, 0.0
+	if v, err := strconv.Atoi(os.Getenv("BATCH_MAX_CONCURRENCY")); err == nil && v > 0 {
+		maxWorkers = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("BATCH_MAX_RATE"), 64); err == nil && v > 0 && batch.ValidRate(v) {
+		maxRate = v
+	}
+
+	opts := batch.Options{Concurrency: min(defaultBatchConcurrency, maxWorkers), Rate: maxRate}
+	if v := batchParam(c, "concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxWorkers {
+			return opts, fmt.Sprintf("Concurrency must be between 1 and %d", maxWorkers)
+		}
+		opts.Concurrency = n
+	}
+	if v := batchParam(c, "rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 || !batch.ValidRate(rate) {
+			return opts, fmt.Sprintf("Rate must be a positive number of requests per second, at most %d", batch.MaxRate)
+		}
+		if maxRate > 0 && rate > maxRate {
+			return opts, fmt.Sprintf("Rate must be at most %g requests per second", maxRate)
+		}
+		opts.Rate = rate
+	}
+	return opts, ""
+}
+
+func batchParam(c *fiber.Ctx, name string) string {
+	if v := c.Query(name); v != "" {
+		return v
+	}
+	return c.FormValue(name)
+}
@@ -0,0 +1,232 @@
+// api/cache.go
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/cache"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// Cache statuses reported in CacheInfo and, upper-cased, in the X-Cache header
+const (
+	cacheHit    = "hit"
+	cacheMiss   = "miss"
+	cacheBypass = "bypass"
+)
+
+// Cache tiers a hit can come from
+const (
+	tierMemory   = "memory"
+	tierPostgres = "postgres"
+)
+
+// CacheInfo tells a client whether a response came from the cache.
+type CacheInfo struct {
+	Status    string     `json:"status"`
+	Tier      string     `json:"tier,omitempty"`
+	Key       string     `json:"key"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// responseCache stores generated dialogues by request, in memory and
+// optionally in the generation_cache table.
+type responseCache struct {
+	memory   *cache.LRU
+	ttl      time.Duration
+	postgres bool
+}
+
+// dialogueCache caches GenerateDialogue responses, configured by InitGenerator
+var dialogueCache = &responseCache{
+	memory: cache.NewLRU(256),
+	ttl:    24 * time.Hour,
+}
+
+// loadResponseCache configures the cache from GENERATION_CACHE_SIZE (entries
+// kept in memory, default 256), GENERATION_CACHE_TTL (default 24h; 0 turns
+// caching off) and GENERATION_CACHE_POSTGRES, which adds the database tier.
+func loadResponseCache() (*responseCache, error) {
+	size := 256
+	if v := os.Getenv("GENERATION_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid GENERATION_CACHE_SIZE %q", v)
+		}
+		size = n
+	}
+
+	rc := &responseCache{memory: cache.NewLRU(size), ttl: dialogueCache.ttl}
+	if v := os.Getenv("GENERATION_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid GENERATION_CACHE_TTL %q", v)
+		}
+		rc.ttl = ttl
+	}
+	if v := os.Getenv("GENERATION_CACHE_POSTGRES"); v != "" {
+		postgres, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GENERATION_CACHE_POSTGRES %q", v)
+		}
+		rc.postgres = postgres
+	}
+
+	if rc.postgres {
+		if n, err := db.DeleteExpiredGenerations(); err != nil {
+			log.Printf("Failed to clear expired cached generations: %v", err)
+		} else if n > 0 {
+			log.Printf("Cleared %d expired cached generations", n)
+		}
+	}
+
+	return rc, nil
+}
+
+func (rc *responseCache) enabled() bool {
+	return rc.ttl > 0
+}
+
+// get looks a key up in memory, then in Postgres.
+func (rc *responseCache) get(key string) (*DialogueResponse, *CacheInfo, bool) {
+	tier := tierMemory
+	data, expires, ok := rc.memory.Get(key)
+	if !ok && rc.postgres {
+		var err error
+		data, expires, err = db.GetCachedGeneration(key)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to read cached generation: %v", err)
+			}
+			return nil, nil, false
+		}
+		tier = tierPostgres
+		rc.memory.Put(key, data, expires)
+		ok = true
+	}
+	if !ok {
+		return nil, nil, false
+	}
+
+	var dialogue DialogueResponse
+	if err := json.Unmarshal(data, &dialogue); err != nil {
+		log.Printf("Failed to decode cached generation: %v", err)
+		return nil, nil, false
+	}
+	return &dialogue, &CacheInfo{Status: cacheHit, Tier: tier, Key: key, ExpiresAt: &expires}, true
+}
+
+// put stores a response in every tier.
+func (rc *responseCache) put(key string, dialogue DialogueResponse) *time.Time {
+	dialogue.Cache = nil
+	data, err := json.Marshal(dialogue)
+	if err != nil {
+		log.Printf("Failed to encode generation for the cache: %v", err)
+		return nil
+	}
+
+	expires := time.Now().Add(rc.ttl)
+	rc.memory.Put(key, data, expires)
+	if rc.postgres {
+		if err := db.PutCachedGeneration(key, data, expires); err != nil {
+			log.Printf("Failed to store cached generation: %v", err)
+		}
+	}
+	return &expires
+}
+
+// cachePolicy reads a client's caching wishes. X-Cache-Bypass or
+// "Cache-Control: no-cache" skip the lookup but still store the result;
+// "Cache-Control: no-store" also keeps the result out of the cache.
+func cachePolicy(c *fiber.Ctx) (read, write bool) {
+	read, write = true, true
+	if bypass, _ := strconv.ParseBool(c.Get("X-Cache-Bypass")); bypass {
+		read = false
+	}
+	cacheControl := strings.ToLower(c.Get(fiber.HeaderCacheControl))
+	if strings.Contains(cacheControl, "no-cache") {
+		read = false
+	}
+	if strings.Contains(cacheControl, "no-store") {
+		read, write = false, false
+	}
+	return read, write
+}
+
+// cachedDialogue is generateDialogue behind dialogueCache. read and write say
+// whether the cache may answer the request and whether to store the result.
+func cachedDialogue(ctx context.Context, req DialogueRequest, read, write bool) (*DialogueResponse, error) {
+	if !dialogueCache.enabled() {
+		return generateDialogue(ctx, req)
+	}
+
+	references, err := selectReferences(req)
+	if err != nil {
+		return nil, err
+	}
+	key := dialogueCacheKey(req, references)
+
+	if read {
+		if dialogue, info, ok := dialogueCache.get(key); ok {
+			dialogue.Cache = info
+			dialogue.Usage = nil
+			return dialogue, nil
+		}
+	}
+
+	dialogue, err := generateWithReferences(ctx, req, references)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CacheInfo{Status: cacheMiss, Key: key}
+	if !read {
+		info.Status = cacheBypass
+	}
+	if write && len(dialogue.Exchanges) > 0 {
+		info.ExpiresAt = dialogueCache.put(key, *dialogue)
+	}
+	dialogue.Cache = info
+	return dialogue, nil
+}
+
+// dialogueCacheKey addresses a request by everything that shapes its result:
+// the provider and model, the full prompt with any references, the sampling
+// parameters including the seed, and the options used to parse the reply.
+func dialogueCacheKey(req DialogueRequest, references []db.ReferenceDialogue) string {
+	llmReq := dialogueLLMRequest(req, references)
+	if llmReq.Model == "" {
+		llmReq.Model = generator.DefaultModel()
+	}
+
+	data, _ := json.Marshal(struct {
+		Provider      string
+		Request       llm.Request
+		Characters    []CharacterRequest
+		Format        string
+		EmotionSource string
+		Candidates    int
+	}{
+		Provider:      generator.Name(),
+		Request:       llmReq,
+		Characters:    req.Characters,
+		Format:        req.Format,
+		EmotionSource: req.EmotionSource,
+		Candidates:    max(req.Candidates, 1),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,246 @@
+// api/candidates.go
+package api
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/search"
+)
+
+const maxCandidates = 5
+
+// Each candidate after the first samples a little hotter than the one before
+const candidateTemperatureStep = 0.15
+
+// Candidate is one take of a multi-candidate generation.
+type Candidate struct {
+	Rank        int                `json:"rank"`
+	Score       float64            `json:"score"`
+	Metrics     CandidateMetrics   `json:"metrics"`
+	Temperature float64            `json:"temperature"`
+	Seed        int64              `json:"seed"`
+	Exchanges   []DialogueExchange `json:"exchanges"`
+	Rejected    []RejectedLine     `json:"rejected,omitempty"`
+
+	settings *GenerationSettings
+}
+
+// CandidateMetrics are local quality heuristics, each from 0 (worst) to 1.
+type CandidateMetrics struct {
+	ExchangeCount   float64 `json:"exchangeCount"`   // closeness to the requested number of exchanges
+	SpeakerCoverage float64 `json:"speakerCoverage"` // share of characters who speak
+	Alternation     float64 `json:"alternation"`     // share of consecutive lines with a change of speaker
+	ParsedShare     float64 `json:"parsedShare"`     // share of output lines the parser could use
+	Freshness       float64 `json:"freshness"`       // 1 minus the share of repeated phrases
+	TraitPresence   float64 `json:"traitPresence"`   // share of characters whose traits show in their lines
+}
+
+// How much each metric counts toward a candidate's score
+var candidateWeights = CandidateMetrics{
+	ExchangeCount:   0.25,
+	SpeakerCoverage: 0.15,
+	Alternation:     0.15,
+	ParsedShare:     0.2,
+	Freshness:       0.15,
+	TraitPresence:   0.1,
+}
+
+// generateCandidates generates req.Candidates takes concurrently, each with
+// its own temperature and seed, and returns the best one with all takes
+// ranked. Takes that fail are dropped unless every take fails. Seeds count
+// up from the requested seed, or from 1.
+func generateCandidates(ctx context.Context, req DialogueRequest, references []db.ReferenceDialogue) (*DialogueResponse, error) {
+	candidates := make([]*Candidate, req.Candidates)
+	errs := make([]error, req.Candidates)
+
+	var wg sync.WaitGroup
+	for i := range candidates {
+		llmReq := dialogueLLMRequest(req, references)
+		temperature := llmReq.Temperature + candidateTemperatureStep*float64(i)
+		llmReq.Temperature = math.Min(math.Round(temperature*100)/100, limits.maxTemperature)
+		seed := int64(i + 1)
+		if llmReq.Seed != nil {
+			seed = *llmReq.Seed + int64(i)
+		}
+		llmReq.Seed = &seed
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			t, err := generateTake(ctx, req, llmReq)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			metrics := scoreTake(req, t)
+			candidates[i] = &Candidate{
+				Score:       metrics.weighted(candidateWeights),
+				Metrics:     metrics,
+				Temperature: llmReq.Temperature,
+				Seed:        *llmReq.Seed,
+				Exchanges:   t.exchanges,
+				Rejected:    t.rejected,
+				settings:    t.settings,
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var ranked []Candidate
+	for _, c := range candidates {
+		if c != nil {
+			ranked = append(ranked, *c)
+		}
+	}
+	if len(ranked) == 0 {
+		return nil, errs[0]
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	for i := range ranked {
+		ranked[i].Rank = i + 1
+	}
+
+	return &DialogueResponse{
+		Scenario:     req.Scenario,
+		Exchanges:    ranked[0].Exchanges,
+		ReferenceIDs: referenceIDs(references),
+		Rejected:     ranked[0].Rejected,
+		Candidates:   ranked,
+		Settings:     ranked[0].settings,
+	}, nil
+}
+
+func (m CandidateMetrics) weighted(w CandidateMetrics) float64 {
+	score := m.ExchangeCount*w.ExchangeCount +
+		m.SpeakerCoverage*w.SpeakerCoverage +
+		m.Alternation*w.Alternation +
+		m.ParsedShare*w.ParsedShare +
+		m.Freshness*w.Freshness +
+		m.TraitPresence*w.TraitPresence
+	return math.Round(score*1000) / 1000
+}
+
+// scoreTake measures a take with the local heuristics.
+func scoreTake(req DialogueRequest, t *take) CandidateMetrics {
+	var lines []DialogueExchange
+	for _, exchange := range t.exchanges {
+		if exchange.Type == beatDialogue {
+			lines = append(lines, exchange)
+		}
+	}
+
+	m := CandidateMetrics{
+		ExchangeCount:   1,
+		SpeakerCoverage: 0,
+		Alternation:     1,
+		ParsedShare:     1,
+		Freshness:       freshness(lines),
+		TraitPresence:   traitPresence(req.Characters, lines),
+	}
+
+	if req.NumExchanges > 0 {
+		diff := math.Abs(float64(len(lines) - req.NumExchanges))
+		m.ExchangeCount = math.Max(0, 1-diff/float64(req.NumExchanges))
+	}
+
+	speakers := make(map[string]bool)
+	changes := 0
+	for i, line := range lines {
+		speakers[line.Character] = true
+		if i > 0 && line.Character != lines[i-1].Character {
+			changes++
+		}
+	}
+	if len(req.Characters) > 0 {
+		m.SpeakerCoverage = float64(len(speakers)) / float64(len(req.Characters))
+	}
+	if len(lines) > 1 {
+		m.Alternation = float64(changes) / float64(len(lines)-1)
+	}
+
+	if total := len(t.exchanges) + len(t.rejected); total > 0 {
+		m.ParsedShare = float64(len(t.exchanges)) / float64(total)
+	}
+
+	return m.rounded()
+}
+
+// freshness is the share of distinct word trigrams across all lines, so
+// repeated lines and stock phrases lower it.
+func freshness(lines []DialogueExchange) float64 {
+	seen := make(map[string]bool)
+	total := 0
+	for _, line := range lines {
+		words := strings.Fields(strings.ToLower(line.Line))
+		for i := 0; i+3 <= len(words); i++ {
+			seen[strings.Join(words[i:i+3], " ")] = true
+			total++
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(len(seen)) / float64(total)
+}
+
+// traitPresence is the share of characters with traits who use a word sharing
+// a stem with one of their traits, e.g. "cynic" for "cynical".
+func traitPresence(characters []CharacterRequest, lines []DialogueExchange) float64 {
+	spoken := make(map[string][]string)
+	for _, line := range lines {
+		spoken[line.Character] = append(spoken[line.Character], search.Tokenize(line.Line)...)
+	}
+
+	withTraits, shown := 0, 0
+	for _, char := range characters {
+		if len(char.Traits) == 0 {
+			continue
+		}
+		withTraits++
+		if sharesStem(char.Traits, spoken[char.Name]) {
+			shown++
+		}
+	}
+	if withTraits == 0 {
+		return 1
+	}
+	return float64(shown) / float64(withTraits)
+}
+
+func sharesStem(traits []string, words []string) bool {
+	for _, trait := range traits {
+		for _, t := range search.Tokenize(trait) {
+			stem := t
+			if len(stem) > 5 {
+				stem = stem[:5]
+			}
+			for _, word := range words {
+				if strings.HasPrefix(word, stem) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (m CandidateMetrics) rounded() CandidateMetrics {
+	r := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	return CandidateMetrics{
+		ExchangeCount:   r(m.ExchangeCount),
+		SpeakerCoverage: r(m.SpeakerCoverage),
+		Alternation:     r(m.Alternation),
+		ParsedShare:     r(m.ParsedShare),
+		Freshness:       r(m.Freshness),
+		TraitPresence:   r(m.TraitPresence),
+	}
+}
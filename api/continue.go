@@ -0,0 +1,166 @@
+// api/continue.go
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// historyTokenBudget bounds how much of an earlier scene is replayed to the
+// model when continuing it
+const historyTokenBudget = 1500
+
+// ContinueDialogue generates more exchanges for an inline dialogue, given as a
+// DialogueRequest whose history holds the scene so far.
+func ContinueDialogue(c *fiber.Ctx) error {
+	var req DialogueRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if len(req.History) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "History is required",
+		})
+	}
+
+	return continueDialogue(c, req, 0)
+}
+
+// ContinueSavedDialogue generates more exchanges for a saved dialogue and
+// appends them, keeping the previous content as a revision. The body may set
+// numExchanges, style, emotionalTone and the other generation options.
+func ContinueSavedDialogue(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dialogue ID",
+		})
+	}
+
+	var req DialogueRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	saved, status, err := loadSavedScene(id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	req.Scenario, req.Characters, req.History = saved.Scenario, saved.Characters, saved.Exchanges
+
+	return continueDialogue(c, req, id)
+}
+
+func continueDialogue(c *fiber.Ctx, req DialogueRequest, dialogueID int) error {
+	if msg := validateDialogueRequest(&req); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	defaultBeatTypes(req.History)
+
+	dialogue, err := generateDialogue(c.UserContext(), req)
+	if err != nil {
+		return respondWithGenerationError(c, "continue dialogue", err)
+	}
+
+	recordUsage(c.Route().Path, apiKeyFingerprint(c), dialogue.Usage)
+
+	added := dropRepeatedLines(req.History, dialogue.Exchanges)
+	dialogue.Exchanges = append(append([]DialogueExchange(nil), req.History...), added...)
+
+	return respondWithEdit(c, *dialogue, dialogueID, fmt.Sprintf("continued with %d exchanges", len(added)))
+}
+
+// dropRepeatedLines removes new exchanges that repeat a line already in the
+// scene, which models tend to do when echoing their context.
+func dropRepeatedLines(history, added []DialogueExchange) []DialogueExchange {
+	seen := make(map[string]bool, len(history))
+	for _, exchange := range history {
+		seen[formatExchange(exchange)] = true
+	}
+
+	var kept []DialogueExchange
+	for _, exchange := range added {
+		key := formatExchange(exchange)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, exchange)
+	}
+	return kept
+}
+
+// writeHistory adds the scene so far, keeping the most recent exchanges that
+// fit in budget tokens and summarizing the ones left out.
+func writeHistory(sb *strings.Builder, history []DialogueExchange, budget int) {
+	start := len(history)
+	for start > 0 {
+		cost := llm.EstimateTokens(formatExchange(history[start-1])) + 1
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start--
+	}
+
+	sb.WriteString("\nThe dialogue so far:\n")
+	if start > 0 {
+		sb.WriteString(summarizeHistory(history[:start]) + "\n")
+	}
+	for _, exchange := range history[start:] {
+		sb.WriteString(formatExchange(exchange) + "\n")
+	}
+}
+
+// summarizeHistory describes exchanges left out of the prompt: how many there
+// were, who spoke, and how the scene opened.
+func summarizeHistory(omitted []DialogueExchange) string {
+	var speakers []string
+	seen := make(map[string]bool)
+	opening := ""
+	for _, exchange := range omitted {
+		if exchange.Type != beatDialogue {
+			continue
+		}
+		if opening == "" {
+			opening = formatExchange(exchange)
+		}
+		if !seen[exchange.Character] {
+			seen[exchange.Character] = true
+			speakers = append(speakers, exchange.Character)
+		}
+	}
+
+	summary := fmt.Sprintf("(%d earlier lines omitted for length", len(omitted))
+	if len(speakers) > 0 {
+		summary += fmt.Sprintf(", spoken by %s", strings.Join(speakers, ", "))
+	}
+	if opening != "" {
+		summary += fmt.Sprintf("; the scene opened with %q", opening)
+	}
+	return summary + ")"
+}
+
+// continuationTask asks for more exchanges that pick up where the scene ended.
+func continuationTask(req DialogueRequest) string {
+	task := fmt.Sprintf("Please continue the dialogue with %d more exchanges. Keep every character's voice consistent with the scene so far and do not repeat earlier lines.", req.NumExchanges)
+
+	for i := len(req.History) - 1; i >= 0; i-- {
+		if req.History[i].Type == beatDialogue {
+			task += fmt.Sprintf(" The last line was spoken by %s, so the next line should come from another character.", req.History[i].Character)
+			break
+		}
+	}
+	return task
+}
@@ -0,0 +1,265 @@
+// api/edit.go
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// scene is a dialogue being edited, either inline or loaded from the dialogues table.
+type scene struct {
+	Scenario      string             `json:"scenario"`
+	Characters    []CharacterRequest `json:"characters"`
+	Exchanges     []DialogueExchange `json:"exchanges"`
+	Style         string             `json:"style"`
+	EmotionalTone string             `json:"emotionalTone"`
+}
+
+type RegenerateLineRequest struct {
+	scene
+
+	// Index is the zero-based position of the exchange to rewrite
+	Index       int    `json:"index"`
+	Instruction string `json:"instruction"`
+
+	// Settings optionally overrides the model and sampling parameters
+	Settings *GenerationSettings `json:"settings,omitempty"`
+}
+
+// EditedDialogueResponse is a dialogue after an edit. DialogueID and
+// RevisionID are set when the dialogue was saved.
+type EditedDialogueResponse struct {
+	DialogueResponse
+	DialogueID int `json:"dialogueId,omitempty"`
+	RevisionID int `json:"revisionId,omitempty"`
+}
+
+// RegenerateLine rewrites one exchange of an inline dialogue.
+func RegenerateLine(c *fiber.Ctx) error {
+	var req RegenerateLineRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	return regenerateLine(c, req, 0)
+}
+
+// RegenerateSavedLine rewrites one exchange of a saved dialogue and stores the
+// result, keeping the previous content as a revision.
+func RegenerateSavedLine(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dialogue ID",
+		})
+	}
+
+	var req RegenerateLineRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	saved, status, err := loadSavedScene(id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	req.Scenario, req.Characters, req.Exchanges = saved.Scenario, saved.Characters, saved.Exchanges
+
+	return regenerateLine(c, req, id)
+}
+
+func regenerateLine(c *fiber.Ctx, req RegenerateLineRequest, dialogueID int) error {
+	if len(req.Exchanges) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Exchanges are required",
+		})
+	}
+	if req.Index < 0 || req.Index >= len(req.Exchanges) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Index must be between 0 and %d", len(req.Exchanges)-1),
+		})
+	}
+	if msg := req.Settings.validate(limits); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	defaultBeatTypes(req.Exchanges)
+
+	ctx, meter := withUsageMeter(c.UserContext())
+	exchange, settings, err := regenerateExchange(ctx, req.scene, req.Index, req.Instruction, req.Settings)
+	if err != nil {
+		return respondWithGenerationError(c, "regenerate line", err)
+	}
+
+	exchanges := append([]DialogueExchange(nil), req.Exchanges...)
+	exchanges[req.Index] = exchange
+
+	note := fmt.Sprintf("regenerated exchange %d", req.Index)
+	if req.Instruction != "" {
+		note += ": " + req.Instruction
+	}
+	dialogue := DialogueResponse{
+		Scenario:  req.Scenario,
+		Exchanges: exchanges,
+		Settings:  settings,
+		Usage:     meter.total(),
+	}
+	recordUsage(c.Route().Path, apiKeyFingerprint(c), dialogue.Usage)
+	return respondWithEdit(c, dialogue, dialogueID, note)
+}
+
+// respondWithEdit saves an edited dialogue when it is persisted and returns it.
+func respondWithEdit(c *fiber.Ctx, dialogue DialogueResponse, dialogueID int, note string) error {
+	resp := EditedDialogueResponse{
+		DialogueResponse: dialogue,
+		DialogueID:       dialogueID,
+	}
+
+	if dialogueID != 0 {
+		content, err := json.Marshal(dialogue.Exchanges)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to serialize exchanges",
+			})
+		}
+		resp.RevisionID, err = db.ReviseGeneratedDialogue(dialogueID, content, note)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save dialogue",
+			})
+		}
+	}
+
+	return c.JSON(resp)
+}
+
+// loadSavedScene reads a saved dialogue, returning an HTTP status to use on error.
+func loadSavedScene(id int) (*scene, int, error) {
+	saved, err := db.GetGeneratedDialogue(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fiber.StatusNotFound, errors.New("Dialogue not found")
+	}
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to fetch dialogue")
+	}
+
+	s := &scene{Scenario: saved.Scenario}
+	if err := json.Unmarshal(saved.Characters, &s.Characters); err != nil {
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to read saved characters")
+	}
+	if err := json.Unmarshal(saved.Content, &s.Exchanges); err != nil {
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to read saved exchanges")
+	}
+	return s, 0, nil
+}
+
+// defaultBeatTypes marks untyped exchanges, such as those saved before beats
+// were typed, as dialogue.
+func defaultBeatTypes(exchanges []DialogueExchange) {
+	for i := range exchanges {
+		if exchanges[i].Type == "" {
+			exchanges[i].Type = beatDialogue
+		}
+	}
+}
+
+// Sampling defaults for rewriting a single beat: a little hotter than a full
+// generation, so the new version differs, and only room for one line
+const (
+	rewriteTemperature = 0.9
+	rewriteMaxTokens   = 200
+)
+
+// regenerateExchange asks the model for a new version of one beat, showing it
+// the rest of the scene as context. It returns the settings the request ran
+// with.
+func regenerateExchange(ctx context.Context, s scene, index int, instruction string, settings *GenerationSettings) (DialogueExchange, *GenerationSettings, error) {
+	original := s.Exchanges[index]
+
+	ctx, cancel := context.WithTimeout(ctx, generateTimeout)
+	defer cancel()
+
+	llmReq := llm.Request{
+		SystemPrompt: dialogueSystemPrompt,
+		Prompt:       buildRewritePrompt(s, index, instruction),
+		Speakers:     []string{original.Character},
+		Turns:        1,
+	}
+	settings.applyWithDefaults(&llmReq, rewriteTemperature, rewriteMaxTokens)
+
+	resp, err := callProvider(ctx, llmReq)
+	if err != nil {
+		return DialogueExchange{}, nil, err
+	}
+
+	// Inline scenes may not list the speaker, who must still be recognised
+	characters := s.Characters
+	if original.Type == beatDialogue && original.Character != "" {
+		if _, ok := newCast(characters).resolve(original.Character); !ok {
+			characters = append(slices.Clone(characters), CharacterRequest{Name: original.Character})
+		}
+	}
+
+	beats, _ := parseDialogueResponse(resp.Text, characters)
+	for _, beat := range beats {
+		if beat.Type != original.Type {
+			continue
+		}
+		// The speaker stays the same even if the model wandered
+		beat.Character = original.Character
+		annotateEmotion(&beat)
+		return beat, usedSettings(llmReq, resp.Model), nil
+	}
+
+	return DialogueExchange{}, nil, &llm.Error{Code: llm.CodeUpstream, Message: "the model did not return a usable line"}
+}
+
+func buildRewritePrompt(s scene, index int, instruction string) string {
+	var sb strings.Builder
+	original := s.Exchanges[index]
+
+	sb.WriteString(fmt.Sprintf("Scenario: %s\n\n", s.Scenario))
+	writeCharacters(&sb, s.Characters)
+	writeStyle(&sb, s.Style, s.EmotionalTone)
+
+	sb.WriteString("\nHere is the scene. The line marked with >>> needs to be rewritten:\n\n")
+	for i, exchange := range s.Exchanges {
+		marker := "   "
+		if i == index {
+			marker = ">>>"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s\n", marker, formatExchange(exchange)))
+	}
+
+	sb.WriteString("\nWrite a new version of the marked line that fits naturally between the lines around it.")
+	if instruction != "" {
+		sb.WriteString(fmt.Sprintf(" Instruction: %s.", strings.TrimSuffix(instruction, ".")))
+	}
+
+	switch original.Type {
+	case beatAction:
+		sb.WriteString("\nReply with only the new stage direction in square brackets, like [She slams the door.]\n")
+	case beatTransition:
+		sb.WriteString("\nReply with only the new transition in capitals, like CUT TO:\n")
+	default:
+		sb.WriteString(fmt.Sprintf("\nReply with only the new line, spoken by %s, formatted as:\n%s: (optional delivery cue) Their dialogue line here.\n", original.Character, original.Character))
+	}
+
+	return sb.String()
+}
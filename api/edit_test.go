@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// postRegenerate sends body to POST /api/dialogues/regenerate and decodes the
+// JSON reply.
+func postRegenerate(t *testing.T, body string) (int, map[string]any) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/api/dialogues/regenerate", RegenerateLine)
+
+	req := httptest.NewRequest("POST", "/api/dialogues/regenerate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply map[string]any
+	if err := json.Unmarshal(data, &reply); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return resp.StatusCode, reply
+}
+
+func TestRegenerateLineWithoutCharacters(t *testing.T) {
+	useMockGenerator(t, &llm.Mock{Script: "ANNA: I never left the house."})
+
+	status, reply := postRegenerate(t, `{
+		"scenario": "An interrogation",
+		"exchanges": [
+			{"character": "Smith", "line": "Where were you?"},
+			{"character": "Anna", "line": "Home."}
+		],
+		"index": 1
+	}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d (%v), want 200", status, reply)
+	}
+
+	exchanges, _ := reply["exchanges"].([]any)
+	if len(exchanges) != 2 {
+		t.Fatalf("exchanges = %v, want 2", reply["exchanges"])
+	}
+	rewritten, _ := exchanges[1].(map[string]any)
+	if rewritten["character"] != "Anna" || rewritten["line"] != "I never left the house." {
+		t.Errorf("rewritten exchange = %v, want Anna's new line", rewritten)
+	}
+}
+
+func TestRegenerateLineUnusableReply(t *testing.T) {
+	useMockGenerator(t, &llm.Mock{Script: "[She says nothing.]"})
+
+	status, reply := postRegenerate(t, `{
+		"scenario": "An interrogation",
+		"characters": [{"name": "Anna"}, {"name": "Smith"}],
+		"exchanges": [{"character": "Anna", "line": "Home."}],
+		"index": 0
+	}`)
+	if status != fiber.StatusBadGateway || reply["code"] != llm.CodeUpstream {
+		t.Errorf("got %d %v, want 502 with code %q", status, reply, llm.CodeUpstream)
+	}
+}
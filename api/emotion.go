@@ -0,0 +1,122 @@
+// api/emotion.go
+package api
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Where per-line emotions come from, set by DialogueRequest.EmotionSource
+const (
+	emotionFromLexicon = "lexicon"
+	emotionFromModel   = "model"
+)
+
+const emotionNeutral = "neutral"
+
+// emotionLabels are the labels the lexicon classifier produces and the model
+// is asked to choose from, in tie-breaking order.
+var emotionLabels = []string{"anger", "fear", "sadness", "joy", "surprise", "disgust", emotionNeutral}
+
+// emotionSynonyms maps words a model may use for an emotion to its label.
+var emotionSynonyms = map[string]string{
+	"angry": "anger", "furious": "anger", "rage": "anger", "annoyed": "anger", "hostile": "anger",
+	"afraid": "fear", "scared": "fear", "nervous": "fear", "anxious": "fear", "tense": "fear", "terrified": "fear",
+	"sad": "sadness", "grief": "sadness", "melancholy": "sadness", "regret": "sadness", "sorrow": "sadness",
+	"happy": "joy", "joyful": "joy", "excited": "joy", "amused": "joy", "relieved": "joy", "hopeful": "joy",
+	"surprised": "surprise", "shocked": "surprise", "astonished": "surprise",
+	"disgusted": "disgust", "contempt": "disgust", "revulsion": "disgust",
+	"calm": emotionNeutral, "flat": emotionNeutral,
+}
+
+// emotionLexicon is a small keyword lexicon for inferring a line's emotion.
+var emotionLexicon = map[string]string{
+	"hate": "anger", "damn": "anger", "kill": "anger", "furious": "anger", "liar": "anger",
+	"enough": "anger", "dare": "anger", "angry": "anger", "sick": "anger", "shut": "anger",
+	"betrayed": "anger", "fool": "anger", "idiot": "anger", "pay": "anger",
+	"afraid": "fear", "scared": "fear", "run": "fear", "hide": "fear", "danger": "fear",
+	"please": "fear", "help": "fear", "careful": "fear", "dead": "fear", "trap": "fear",
+	"quiet": "fear", "hurry": "fear", "terrified": "fear", "watching": "fear",
+	"sorry": "sadness", "miss": "sadness", "lost": "sadness", "alone": "sadness", "gone": "sadness",
+	"cry": "sadness", "tears": "sadness", "goodbye": "sadness", "never": "sadness", "forgive": "sadness",
+	"love": "joy", "great": "joy", "wonderful": "joy", "happy": "joy", "laugh": "joy",
+	"finally": "joy", "together": "joy", "thank": "joy", "beautiful": "joy", "won": "joy",
+	"what": "surprise", "really": "surprise", "impossible": "surprise", "wait": "surprise",
+	"unbelievable": "surprise", "how": "surprise", "suddenly": "surprise",
+	"disgusting": "disgust", "filthy": "disgust", "gross": "disgust", "vile": "disgust",
+	"pathetic": "disgust", "rotten": "disgust", "revolting": "disgust",
+}
+
+// emotionTagPattern matches a trailing "{anger 0.8}" annotation on a line.
+var emotionTagPattern = regexp.MustCompile(`\s*\{\s*([A-Za-z]+)\s*[,:]?\s*([01](?:\.\d+)?)?\s*\}\s*$`)
+
+// extractEmotionTag removes a trailing emotion annotation from a line.
+func extractEmotionTag(line string) (text, emotion string, intensity float64) {
+	m := emotionTagPattern.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line, "", 0
+	}
+
+	emotion = normalizeEmotion(line[m[2]:m[3]])
+	intensity = 0.5
+	if m[4] >= 0 {
+		intensity, _ = strconv.ParseFloat(line[m[4]:m[5]], 64)
+	}
+	return strings.TrimSpace(line[:m[0]]), emotion, clampUnit(intensity)
+}
+
+// normalizeEmotion maps an emotion word to a known label, keeping unknown
+// words as given.
+func normalizeEmotion(emotion string) string {
+	emotion = strings.ToLower(strings.TrimSpace(emotion))
+	if label, ok := emotionSynonyms[emotion]; ok {
+		return label
+	}
+	return emotion
+}
+
+// classifyEmotion infers a line's emotion from the lexicon. Intensity grows
+// with the number of emotional words, exclamation marks and shouted words.
+func classifyEmotion(line string) (string, float64) {
+	counts := make(map[string]int)
+	shouted := 0
+	for _, word := range strings.FieldsFunc(line, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		if len(word) > 1 && strings.ToUpper(word) == word {
+			shouted++
+		}
+		if label, ok := emotionLexicon[strings.ToLower(word)]; ok {
+			counts[label]++
+		}
+	}
+	exclamations := strings.Count(line, "!")
+	arousal := 0.1*float64(min(exclamations, 3)) + 0.1*float64(min(shouted, 2))
+
+	label, hits := emotionNeutral, 0
+	for _, l := range emotionLabels {
+		if counts[l] > hits {
+			label, hits = l, counts[l]
+		}
+	}
+	if hits == 0 {
+		return emotionNeutral, clampUnit(0.1 + arousal)
+	}
+	return label, clampUnit(0.3 + 0.15*float64(hits) + arousal)
+}
+
+// annotateEmotion fills in a dialogue beat's emotion from the lexicon when the
+// model did not supply one.
+func annotateEmotion(exchange *DialogueExchange) {
+	if exchange.Type != beatDialogue || exchange.Emotion != "" {
+		return
+	}
+	exchange.Emotion, exchange.Intensity = classifyEmotion(exchange.Line)
+}
+
+func clampUnit(v float64) float64 {
+	return math.Round(math.Max(0, math.Min(1, v))*100) / 100
+}
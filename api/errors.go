@@ -0,0 +1,63 @@
+// api/errors.go
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// codeInternal is the error code for failures that are not the model's
+const codeInternal = "internal_error"
+
+// generationStatuses maps provider error codes to the status returned to clients
+var generationStatuses = map[string]int{
+	llm.CodeModelLoading: fiber.StatusServiceUnavailable,
+	llm.CodeRateLimited:  fiber.StatusServiceUnavailable,
+	llm.CodeUnavailable:  fiber.StatusBadGateway,
+	llm.CodeTimeout:      fiber.StatusGatewayTimeout,
+	llm.CodeUpstream:     fiber.StatusBadGateway,
+	llm.CodeRejected:     fiber.StatusBadGateway,
+}
+
+// generationFailure classifies a generation error, returning the HTTP status,
+// a machine-readable error code and how long the client should wait before
+// retrying, if known.
+func generationFailure(err error) (status int, code string, retryAfter int) {
+	var llmErr *llm.Error
+	switch {
+	case errors.As(err, &llmErr):
+		status, ok := generationStatuses[llmErr.Code]
+		if !ok {
+			status = fiber.StatusBadGateway
+		}
+		return status, llmErr.Code, int(math.Ceil(llmErr.RetryAfter.Seconds()))
+	case errors.Is(err, context.DeadlineExceeded):
+		return fiber.StatusGatewayTimeout, llm.CodeTimeout, 0
+	}
+	return fiber.StatusInternalServerError, codeInternal, 0
+}
+
+// generationError is the JSON body for a failed generation.
+func generationError(action string, err error) fiber.Map {
+	_, code, _ := generationFailure(err)
+	return fiber.Map{
+		"error": fmt.Sprintf("Failed to %s: %v", action, err),
+		"code":  code,
+	}
+}
+
+// respondWithGenerationError sends a failed generation with the status that
+// matches its cause, e.g. "Failed to generate dialogue: ...".
+func respondWithGenerationError(c *fiber.Ctx, action string, err error) error {
+	status, _, retryAfter := generationFailure(err)
+	if retryAfter > 0 {
+		c.Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	return c.Status(status).JSON(generationError(action, err))
+}
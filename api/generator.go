@@ -2,59 +2,122 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
 )
 
 type DialogueRequest struct {
-	Scenario      string              `json:"scenario"`
-	Characters    []CharacterRequest  `json:"characters"`
-	NumExchanges  int                 `json:"numExchanges"`
-	Style         string              `json:"style"`
-	EmotionalTone string              `json:"emotionalTone"`
+	Scenario      string             `json:"scenario"`
+	Characters    []CharacterRequest `json:"characters"`
+	NumExchanges  int                `json:"numExchanges"`
+	Style         string             `json:"style"`
+	EmotionalTone string             `json:"emotionalTone"`
+
+	// EmotionalArc describes how the mood should develop across the scene,
+	// e.g. ["calm", "tense", "explosive"]
+	EmotionalArc []string `json:"emotionalArc,omitempty"`
+	// EmotionSource is "lexicon" (default) to infer per-line emotions locally
+	// or "model" to ask the model to label each line
+	EmotionSource string `json:"emotionSource,omitempty"`
+
+	// Format is "lines" (default) or "json" to have the model return a JSON array
+	Format string `json:"format,omitempty"`
+
+	// Candidates asks for several takes, ranked best first (max 5)
+	Candidates int `json:"candidates,omitempty"`
+
+	// History holds earlier exchanges when continuing a dialogue
+	History []DialogueExchange `json:"history,omitempty"`
+
+	// References optionally pulls reference dialogues into the prompt as style examples
+	References *ReferenceOptions `json:"references,omitempty"`
+
+	// Settings optionally overrides the model and sampling parameters
+	Settings *GenerationSettings `json:"settings,omitempty"`
 }
 
 type CharacterRequest struct {
-	Name  string   `json:"name"`
-	Type  string   `json:"type"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
 	Traits []string `json:"traits"`
+
+	// Aliases are other names the model may use for the character
+	Aliases []string `json:"aliases,omitempty"`
 }
 
 type DialogueResponse struct {
-	Scenario  string             `json:"scenario"`
-	Exchanges []DialogueExchange `json:"exchanges"`
+	Scenario     string             `json:"scenario"`
+	Exchanges    []DialogueExchange `json:"exchanges"`
+	ReferenceIDs []int              `json:"referenceIds,omitempty"`
+	Rejected     []RejectedLine     `json:"rejected,omitempty"`
+	Candidates   []Candidate        `json:"candidates,omitempty"`
+
+	// Settings are the generation settings actually used
+	Settings *GenerationSettings `json:"settings,omitempty"`
+
+	// Cache tells whether the response was served from the cache
+	Cache *CacheInfo `json:"cache,omitempty"`
+	// Usage is the tokens and cost spent on this response; cache hits spend none
+	Usage *Usage `json:"usage,omitempty"`
 }
 
+// DialogueExchange is one beat of a scene. Dialogue beats carry a character
+// and an optional parenthetical delivery cue; action and transition beats
+// carry only their text in Line.
 type DialogueExchange struct {
-	Character string `json:"character"`
-	Line      string `json:"line"`
+	Type          string `json:"type"`
+	Character     string `json:"character"`
+	Line          string `json:"line"`
+	Parenthetical string `json:"parenthetical,omitempty"`
+
+	// Emotion and Intensity (0 to 1) describe how a dialogue line is delivered
+	Emotion   string  `json:"emotion,omitempty"`
+	Intensity float64 `json:"intensity,omitempty"`
 }
 
-// HuggingFace API request structure
-type HuggingFaceRequest struct {
-	Inputs     string `json:"inputs"`
-	Parameters struct {
-		Temperature float64 `json:"temperature"`
-		MaxNewTokens int    `json:"max_new_tokens"`
-		ReturnFullText bool `json:"return_full_text"`
-	} `json:"parameters"`
-}
+// Beat types for DialogueExchange.Type
+const (
+	beatDialogue   = "dialogue"
+	beatAction     = "action"
+	beatTransition = "transition"
+)
 
-// HuggingFace API response structure
-type HuggingFaceResponse struct {
-	GeneratedText string `json:"generated_text"`
-	Error         string `json:"error,omitempty"`
-}
+// generateTimeout bounds a generation, including retries of the provider
+// call, when the caller has not set a deadline
+const generateTimeout = 2 * time.Minute
+
+const dialogueSystemPrompt = "You are a creative dialogue writer that specializes in creating authentic movie-like or anime-like dialogues. Create realistic exchanges between characters based on the described scenario and character traits."
+
+// generator is the text generation backend used by the dialogue handlers
+var generator llm.Provider
 
+// InitGenerator selects the text generation backend from the environment.
+func InitGenerator() {
+	provider, err := llm.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize text generation provider:", err)
+	}
+	generator = provider
+
+	if limits, err = loadGenerationLimits(); err != nil {
+		log.Fatal("Failed to load generation limits:", err)
+	}
+	if dialogueCache, err = loadResponseCache(); err != nil {
+		log.Fatal("Failed to configure the response cache:", err)
+	}
+	if modelPrices, err = loadModelPrices(); err != nil {
+		log.Fatal("Failed to load model prices:", err)
+	}
+
+	log.Printf("Using %s text generation provider", provider.Name())
+}
 
 func GenerateDialogue(c *fiber.Ctx) error {
 	// Parse request
@@ -66,208 +129,251 @@ func GenerateDialogue(c *fiber.Ctx) error {
 	}
 
 	// Validate request
-	if req.Scenario == "" {
+	if msg := validateDialogueRequest(&req); msg != "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Scenario is required",
+			"error": msg,
 		})
 	}
+
+	read, write := cachePolicy(c)
+	dialogue, err := cachedDialogue(c.UserContext(), req, read, write)
+	if err != nil {
+		return respondWithGenerationError(c, "generate dialogue", err)
+	}
+	if dialogue.Cache != nil {
+		c.Set("X-Cache", strings.ToUpper(dialogue.Cache.Status))
+	}
+	recordUsage(c.Route().Path, apiKeyFingerprint(c), dialogue.Usage)
+
+	return c.JSON(dialogue)
+}
+
+// validateDialogueRequest fills in defaults and returns a user-facing error
+// message, or "" if the request is valid.
+func validateDialogueRequest(req *DialogueRequest) string {
+	normalizeDialogueRequest(req)
+	if req.Scenario == "" {
+		return "Scenario is required"
+	}
 	if len(req.Characters) < 2 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "At least two characters are required",
-		})
+		return "At least two characters are required"
 	}
 	if req.NumExchanges <= 0 {
 		req.NumExchanges = 5 // Default to 5 exchanges
 	}
-
-	// Build prompt for AI
-	prompt := buildDialoguePrompt(req)
-		systemPrompt := "You are a creative dialogue writer that specializes in creating authentic movie-like or anime-like dialogues. Create realistic exchanges between characters based on the described scenario and character traits."
-	// Call HuggingFace API
-	formattedPromt := formatLlamaPrompt(systemPrompt, prompt)
-
-	apiKey := os.Getenv("HUGGINGFACE_API_KEY")
-	if apiKey == "" {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "HUGGINGFACE_API_KEY environment variable not set",
-		})
+	if req.Candidates < 0 || req.Candidates > maxCandidates {
+		return fmt.Sprintf("Candidates must be between 1 and %d", maxCandidates)
 	}
-	modelID := os.Getenv("HUGGINGFACE_MODEL_ID")
-	if modelID == "" {
-		// Default to Llama 3.2 3B model if not specified
-		modelID = "meta-llama/Meta-Llama-3.2-3B-Instruct"
+	switch req.EmotionSource {
+	case "":
+		req.EmotionSource = emotionFromLexicon
+	case emotionFromLexicon, emotionFromModel:
+	default:
+		return "Emotion source must be \"lexicon\" or \"model\""
 	}
+	switch req.Format {
+	case "":
+		req.Format = formatLines
+	case formatLines, formatJSON:
+	default:
+		return "Format must be \"lines\" or \"json\""
+	}
+	return req.Settings.validate(limits)
+}
 
-
-	// // Initialize OpenAI client
-	// // Create request for HuggingFace Inference API
-	hfReq := HuggingFaceRequest{
-		Inputs: formattedPromt,
+// normalizeDialogueRequest collapses runs of whitespace in the free-text
+// fields, so requests that differ only in spacing build the same prompt.
+func normalizeDialogueRequest(req *DialogueRequest) {
+	req.Scenario = normalizeSpace(req.Scenario)
+	req.Style = normalizeSpace(req.Style)
+	req.EmotionalTone = normalizeSpace(req.EmotionalTone)
+	for i := range req.Characters {
+		char := &req.Characters[i]
+		char.Name = normalizeSpace(char.Name)
+		char.Type = normalizeSpace(char.Type)
+		for j := range char.Traits {
+			char.Traits[j] = normalizeSpace(char.Traits[j])
+		}
 	}
+}
 
-	// // Set parameters
-	hfReq.Parameters.Temperature = 0.7
-	hfReq.Parameters.MaxNewTokens = 1024
-	hfReq.Parameters.ReturnFullText = false
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
 
-	// // Convert to JSON
-	jsonData, err := json.Marshal(hfReq)
+// generateDialogue runs a validated request through the configured provider
+// and parses the completion into exchanges. Requests for several candidates
+// generate and rank them concurrently.
+func generateDialogue(ctx context.Context, req DialogueRequest) (*DialogueResponse, error) {
+	references, err := selectReferences(req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create request",
-		})
+		return nil, err
 	}
+	return generateWithReferences(ctx, req, references)
+}
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// generateWithReferences generates a dialogue using already selected references.
+func generateWithReferences(ctx context.Context, req DialogueRequest, references []db.ReferenceDialogue) (*DialogueResponse, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, generateTimeout)
+		defer cancel()
 	}
+	ctx, meter := withUsageMeter(ctx)
 
-		// Create request
-		hfURL := fmt.Sprintf("https://api-inference.huggingface.co/models/%s", modelID)
-		request, err := http.NewRequest("POST", hfURL, bytes.NewBuffer(jsonData))
+	var dialogue *DialogueResponse
+	if req.Candidates > 1 {
+		var err error
+		if dialogue, err = generateCandidates(ctx, req, references); err != nil {
+			return nil, err
+		}
+	} else {
+		take, err := generateTake(ctx, req, dialogueLLMRequest(req, references))
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to create request: %v", err),
-			})
+			return nil, err
+		}
+		dialogue = &DialogueResponse{
+			Scenario:     req.Scenario,
+			Exchanges:    take.exchanges,
+			ReferenceIDs: referenceIDs(references),
+			Rejected:     take.rejected,
+			Settings:     take.settings,
 		}
+	}
 
-	// 		// Set headers
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	dialogue.Usage = meter.total()
+	return dialogue, nil
+}
 
-		// Send request
-		resp, err := client.Do(request)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to connect to HuggingFace API: %v", err),
-			})
-		}
-		defer resp.Body.Close()
-	
-		// Read response body
-		body, err := io.ReadAll(resp.Body)
+// take is one parsed completion.
+type take struct {
+	exchanges []DialogueExchange
+	rejected  []RejectedLine
+	settings  *GenerationSettings
+}
+
+// generateTake makes a single completion and parses it.
+func generateTake(ctx context.Context, req DialogueRequest, llmReq llm.Request) (*take, error) {
+	resp, err := callProvider(ctx, llmReq)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &take{settings: usedSettings(llmReq, resp.Model)}
+	if req.Format == formatJSON {
+		t.exchanges, t.rejected, err = exchangesFromJSON(ctx, llmReq, resp.Text, req.Characters)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to read response: %v", err),
-			})
-		}
-	
-		// Check for error status code
-		if resp.StatusCode != http.StatusOK {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("HuggingFace API error (status %d): %s", resp.StatusCode, string(body)),
-			})
-		}
-		log.Printf("Response: %s", string(body))
-	
-		// Parse response
-		var hfResp []HuggingFaceResponse
-		if err := json.Unmarshal(body, &hfResp); err != nil {
-			// Try single response format
-			var singleResp HuggingFaceResponse
-			if err := json.Unmarshal(body, &singleResp); err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to parse HuggingFace response: %v", err),
-				})
-			}
-			hfResp = []HuggingFaceResponse{singleResp}
-		}
-	
-		// Check for errors
-		if len(hfResp) == 0 || hfResp[0].GeneratedText == "" {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "No response generated",
-			})
+			return nil, err
 		}
+	} else {
+		t.exchanges, t.rejected = parseDialogueResponse(resp.Text, req.Characters)
+	}
+	for i := range t.exchanges {
+		annotateEmotion(&t.exchanges[i])
+	}
 
-		log.Printf("Response: %s", hfResp[0].GeneratedText)
-	
-		// Process the AI response
-		aiResponse := hfResp[0].GeneratedText
-		dialogue := parseDialogueResponse(aiResponse, req.Characters)
-	
-		// Format the final response
-		return c.JSON(DialogueResponse{
-			Scenario:  req.Scenario,
-			Exchanges: dialogue,
-		})
-	
+	reportTake(ctx)
+	return t, nil
+}
+
+// dialogueLLMRequest builds the provider request for a dialogue request.
+func dialogueLLMRequest(req DialogueRequest, references []db.ReferenceDialogue) llm.Request {
+	llmReq := llm.Request{
+		SystemPrompt: dialogueSystemPrompt,
+		Prompt:       buildDialoguePrompt(req, references),
+		Speakers:     characterNames(req.Characters),
+		Turns:        req.NumExchanges,
+	}
+	req.Settings.apply(&llmReq)
+	return llmReq
 }
 
-// Format the prompt for Llama 3.2 with system and user roles
-func formatLlamaPrompt(systemMessage, userMessage string) string {
-	// The format for Llama 3.2 chat models
-	prompt := fmt.Sprintf("<|system|>\n%s\n<|user|>\n%s\n<|assistant|>\n", systemMessage, userMessage)
-	return prompt
+func characterNames(characters []CharacterRequest) []string {
+	names := make([]string, len(characters))
+	for i, char := range characters {
+		names[i] = char.Name
+	}
+	return names
 }
 
-func buildDialoguePrompt(req DialogueRequest) string {
+func buildDialoguePrompt(req DialogueRequest, references []db.ReferenceDialogue) string {
 	var sb strings.Builder
 
 	// Add scenario
 	sb.WriteString(fmt.Sprintf("Scenario: %s\n\n", req.Scenario))
-	
+
 	// Add characters with their traits
-	sb.WriteString("Characters:\n")
-	for _, char := range req.Characters {
-		sb.WriteString(fmt.Sprintf("- %s (Type: %s, Traits: %s)\n", 
-			char.Name, 
-			char.Type, 
-			strings.Join(char.Traits, ", ")))
-	}
-	
+	writeCharacters(&sb, req.Characters)
+
 	// Add style and tone
-	if req.Style != "" {
-		sb.WriteString(fmt.Sprintf("\nStyle: %s\n", req.Style))
-	}
-	if req.EmotionalTone != "" {
-		sb.WriteString(fmt.Sprintf("Emotional Tone: %s\n", req.EmotionalTone))
+	writeStyle(&sb, req.Style, req.EmotionalTone)
+	if len(req.EmotionalArc) > 0 {
+		sb.WriteString(fmt.Sprintf("Emotional Arc: move through %s over the course of the exchanges\n", strings.Join(req.EmotionalArc, " → ")))
 	}
-	
-	// Add instructions
-	sb.WriteString(fmt.Sprintf("\nPlease create a dialogue with %d exchanges between these characters in the given scenario. Format the dialogue as:\n", req.NumExchanges))
-	sb.WriteString("CHARACTER_NAME: Their dialogue line here.\n")
-	
-	return sb.String()
-}
 
-func parseDialogueResponse(aiResponse string, characters []CharacterRequest) []DialogueExchange {
-	lines := strings.Split(aiResponse, "\n")
-	var exchanges []DialogueExchange
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	// Add reference dialogues as style examples
+	if len(references) > 0 {
+		sb.WriteString("\nReference dialogues (match their voice and rhythm, but do not copy them):\n")
+		for i, ref := range references {
+			sb.WriteString(fmt.Sprintf("\n--- Example %d (from %s) ---\n%s\n", i+1, ref.Source, strings.TrimSpace(ref.Content)))
 		}
+	}
+
+	// Add the scene so far when continuing a dialogue
+	task := fmt.Sprintf("Please create a dialogue with %d exchanges between these characters in the given scenario.", req.NumExchanges)
+	if len(req.History) > 0 {
+		writeHistory(&sb, req.History, historyTokenBudget)
+		task = continuationTask(req)
+	}
 
-		// Look for "CHARACTER: Dialogue" pattern
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			continue
+	// Add instructions
+	if req.Format == formatJSON {
+		sb.WriteString(fmt.Sprintf("\n%s Respond with only a JSON array matching this schema, with no other text:\n", task))
+		sb.WriteString(dialogueJSONSchema + "\n")
+		if req.EmotionSource == emotionFromModel {
+			sb.WriteString(fmt.Sprintf("Give every dialogue item an \"emotion\" (one of: %s) and an \"intensity\" from 0 to 1.\n", strings.Join(emotionLabels, ", ")))
 		}
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("\n%s Format the dialogue as:\n", task))
+	sb.WriteString("CHARACTER_NAME: (optional delivery cue) Their dialogue line here.\n")
+	if req.EmotionSource == emotionFromModel {
+		sb.WriteString(fmt.Sprintf("End every dialogue line with its emotion (one of: %s) and an intensity from 0 to 1 in braces, like {anger 0.8}.\n", strings.Join(emotionLabels, ", ")))
+	}
+	sb.WriteString("\nYou may add brief stage directions on their own line in square brackets, like [She slams the door.], and scene transitions on their own line in capitals, like CUT TO:\n")
 
-		characterName := strings.TrimSpace(parts[0])
-		dialogueLine := strings.TrimSpace(parts[1])
+	return sb.String()
+}
 
-		// Verify that this character exists in our list
-		characterExists := false
-		for _, char := range characters {
-			if strings.Contains(char.Name, characterName) {
-				characterExists = true
-				break
-			}
-		}
+func writeCharacters(sb *strings.Builder, characters []CharacterRequest) {
+	sb.WriteString("Characters:\n")
+	for _, char := range characters {
+		sb.WriteString(fmt.Sprintf("- %s (Type: %s, Traits: %s)\n",
+			char.Name,
+			char.Type,
+			strings.Join(char.Traits, ", ")))
+	}
+}
 
-		// Only add valid character dialogues
-		if characterExists && dialogueLine != "" {
-			exchanges = append(exchanges, DialogueExchange{
-				Character: characterName,
-				Line:      dialogueLine,
-			})
-		}
+func writeStyle(sb *strings.Builder, style, tone string) {
+	if style != "" {
+		sb.WriteString(fmt.Sprintf("\nStyle: %s\n", style))
 	}
+	if tone != "" {
+		sb.WriteString(fmt.Sprintf("Emotional Tone: %s\n", tone))
+	}
+}
 
-	return exchanges
-}
\ No newline at end of file
+// formatExchange renders a beat back into the script format the model writes.
+func formatExchange(exchange DialogueExchange) string {
+	switch exchange.Type {
+	case beatAction:
+		return fmt.Sprintf("[%s]", exchange.Line)
+	case beatTransition:
+		return exchange.Line
+	}
+	if exchange.Parenthetical != "" {
+		return fmt.Sprintf("%s: (%s) %s", exchange.Character, exchange.Parenthetical, exchange.Line)
+	}
+	return fmt.Sprintf("%s: %s", exchange.Character, exchange.Line)
+}
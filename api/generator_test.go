@@ -0,0 +1,141 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+const generateBody = `{
+	"scenario": "An interrogation at midnight",
+	"characters": [
+		{"name": "Detective Smith", "type": "detective"},
+		{"name": "Anna Reyes", "type": "suspect"}
+	],
+	"numExchanges": 4
+}`
+
+// useMockGenerator points the dialogue handlers at a mock provider. Usage
+// records go to a database that is never reachable, which is only logged.
+func useMockGenerator(t *testing.T, mock *llm.Mock) {
+	t.Helper()
+	prevGenerator, prevDB := generator, db.DB
+	t.Cleanup(func() { generator, db.DB = prevGenerator, prevDB })
+
+	conn, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	generator, db.DB = mock, conn
+}
+
+// postGenerate sends body to POST /api/generate, bypassing the response cache.
+func postGenerate(t *testing.T, body string) (int, DialogueResponse) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/api/generate", GenerateDialogue)
+
+	req := httptest.NewRequest("POST", "/api/generate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cache-Control", "no-store")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialogue DialogueResponse
+	if resp.StatusCode == fiber.StatusOK {
+		if err := json.Unmarshal(data, &dialogue); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+	}
+	return resp.StatusCode, dialogue
+}
+
+func TestGenerateDialogueScript(t *testing.T) {
+	useMockGenerator(t, &llm.Mock{Script: "DETECTIVE SMITH: Where were you at midnight?\n" +
+		"[Anna looks away]\n" +
+		"Anna: (quietly) Home.\n\n" +
+		"Narrator: Nobody believed her."})
+
+	status, dialogue := postGenerate(t, generateBody)
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+
+	want := []DialogueExchange{
+		{Type: beatDialogue, Character: "Detective Smith", Line: "Where were you at midnight?"},
+		{Type: beatAction, Line: "Anna looks away"},
+		{Type: beatDialogue, Character: "Anna Reyes", Line: "Home.", Parenthetical: "quietly"},
+	}
+	got := make([]DialogueExchange, len(dialogue.Exchanges))
+	for i, exchange := range dialogue.Exchanges {
+		// Emotions come from the lexicon; the parse is what is under test
+		exchange.Emotion, exchange.Intensity = "", 0
+		got[i] = exchange
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("exchanges:\n got %+v\nwant %+v", got, want)
+	}
+	if len(dialogue.Rejected) != 1 || dialogue.Rejected[0].Reason != `unknown speaker "Narrator"` {
+		t.Errorf("rejected = %+v, want the narrator's line", dialogue.Rejected)
+	}
+}
+
+func TestGenerateDialogueSeed(t *testing.T) {
+	useMockGenerator(t, &llm.Mock{Seed: 7})
+
+	status, first := postGenerate(t, generateBody)
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(first.Exchanges) != 4 {
+		t.Fatalf("got %d exchanges, want 4", len(first.Exchanges))
+	}
+	for i, exchange := range first.Exchanges {
+		speaker := []string{"Detective Smith", "Anna Reyes"}[i%2]
+		if exchange.Character != speaker || exchange.Line == "" {
+			t.Errorf("exchange %d = %+v, want a line from %s", i, exchange, speaker)
+		}
+	}
+
+	_, second := postGenerate(t, generateBody)
+	if !slices.Equal(first.Exchanges, second.Exchanges) {
+		t.Errorf("same seed gave different dialogues:\n%+v\n%+v", first.Exchanges, second.Exchanges)
+	}
+}
+
+func TestGenerateDialogueInvalidRequest(t *testing.T) {
+	useMockGenerator(t, &llm.Mock{Seed: 1})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed", `{"scenario":`},
+		{"no scenario", `{"characters": [{"name": "A"}, {"name": "B"}]}`},
+		{"one character", `{"scenario": "A duel", "characters": [{"name": "A"}]}`},
+		{"bad format", `{"scenario": "A duel", "characters": [{"name": "A"}, {"name": "B"}], "format": "xml"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := postGenerate(t, tt.body); status != fiber.StatusBadRequest {
+				t.Errorf("status = %d, want 400", status)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package api
 
 import (
 	"encoding/json"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
@@ -28,6 +29,12 @@ func CreateCharacter(c *fiber.Ctx) error {
 		})
 	}
 
+	if msg := validateVoiceProfile(character.Voice); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
 	id, err := db.CreateCharacter(character)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -68,10 +75,34 @@ func AddReferenceDialogue(c *fiber.Ctx) error {
 		})
 	}
 
+	// Keep similarity search in step with the table
+	if err := rebuildReferenceIndex(); err != nil {
+		log.Printf("Failed to rebuild reference index: %v", err)
+	}
+
 	dialogue.ID = id
 	return c.Status(fiber.StatusCreated).JSON(dialogue)
 }
 
+func SearchReferenceDialogues(c *fiber.Ctx) error {
+	query := c.Query("q")
+	if query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Query parameter q is required",
+		})
+	}
+	limit := c.QueryInt("limit", 10)
+
+	results, err := searchReferences(query, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search reference dialogues",
+		})
+	}
+
+	return c.JSON(results)
+}
+
 // Generated dialogue handlers
 func SaveDialogue(c *fiber.Ctx) error {
 	var req struct {
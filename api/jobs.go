@@ -0,0 +1,405 @@
+// api/jobs.go
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+)
+
+// jobTimeout bounds a single job, which may run far longer than an HTTP request
+const jobTimeout = 15 * time.Minute
+
+// jobsEndpoint is the endpoint usage of jobs is recorded under
+const jobsEndpoint = "/api/jobs"
+
+// jobPollInterval is how often idle workers look for jobs queued by other processes
+const jobPollInterval = 10 * time.Second
+
+// A running job's lease is renewed every jobHeartbeatInterval, which is also
+// how quickly a job canceled through another process stops. Jobs whose lease
+// is older than jobLease are taken to be orphaned and are queued again.
+const (
+	jobHeartbeatInterval = 15 * time.Second
+	jobLease             = 4 * jobHeartbeatInterval
+)
+
+// JobRequest queues a dialogue generation. AutoSave stores the result in the
+// dialogues table when the job succeeds.
+type JobRequest struct {
+	DialogueRequest
+	AutoSave bool `json:"autoSave,omitempty"`
+}
+
+// Job is the state of a queued generation.
+type Job struct {
+	ID         int               `json:"id"`
+	Status     string            `json:"status"`
+	Progress   JobProgress       `json:"progress"`
+	Result     *DialogueResponse `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Code       string            `json:"code,omitempty"`
+	DialogueID *int              `json:"dialogueId,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	StartedAt  *time.Time        `json:"startedAt,omitempty"`
+	FinishedAt *time.Time        `json:"finishedAt,omitempty"`
+}
+
+// JobProgress counts finished takes; a job has one take per candidate.
+type JobProgress struct {
+	CompletedTakes int `json:"completedTakes"`
+	TotalTakes     int `json:"totalTakes"`
+}
+
+// jobQueue runs queued jobs on a fixed number of workers. The generation_jobs
+// table is the queue, so jobs outlive the process that accepted them.
+type jobQueue struct {
+	wake chan struct{}
+
+	mu      sync.Mutex
+	running map[int]context.CancelFunc
+}
+
+var jobs *jobQueue
+
+// InitJobs starts the job workers. JOB_WORKERS sets how many jobs run at
+// once (default 2). Jobs whose process stopped renewing their lease, in this
+// or any other instance, are queued again.
+func InitJobs() {
+	workers := 2
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid JOB_WORKERS %q", v)
+		}
+		workers = n
+	}
+
+	jobs = &jobQueue{
+		wake:    make(chan struct{}, workers),
+		running: make(map[int]context.CancelFunc),
+	}
+	if _, err := jobs.requeueExpired(); err != nil {
+		log.Fatal("Failed to requeue interrupted jobs:", err)
+	}
+	for i := 0; i < workers; i++ {
+		go jobs.work()
+	}
+	go jobs.reap()
+
+	log.Printf("Started %d generation job workers", workers)
+}
+
+// CreateJob queues a generation and returns its job ID.
+func CreateJob(c *fiber.Ctx) error {
+	var req JobRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if msg := validateDialogueRequest(&req.DialogueRequest); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	request, err := json.Marshal(req.DialogueRequest)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to serialize request",
+		})
+	}
+
+	id, err := db.CreateGenerationJob(request, req.AutoSave, apiKeyFingerprint(c), max(req.Candidates, 1))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to queue job",
+		})
+	}
+	jobs.notify()
+
+	c.Location(fmt.Sprintf("/api/jobs/%d", id))
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"id":     id,
+		"status": db.JobQueued,
+	})
+}
+
+// GetJob reports a job's status and progress, and its result once it succeeds.
+func GetJob(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid job ID",
+		})
+	}
+
+	job, status, err := loadJob(id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(job)
+}
+
+// CancelJob cancels a queued or running job.
+func CancelJob(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid job ID",
+		})
+	}
+
+	canceled, err := db.CancelGenerationJob(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to cancel job",
+		})
+	}
+	jobs.cancel(id)
+
+	job, status, err := loadJob(id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if !canceled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Job already %s", job.Status),
+		})
+	}
+
+	return c.JSON(job)
+}
+
+// loadJob reads a job, returning an HTTP status to use on error.
+func loadJob(id int) (*Job, int, error) {
+	record, err := db.GetGenerationJob(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fiber.StatusNotFound, errors.New("Job not found")
+	}
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to fetch job")
+	}
+
+	job := &Job{
+		ID:     record.ID,
+		Status: record.Status,
+		Progress: JobProgress{
+			CompletedTakes: record.CompletedTakes,
+			TotalTakes:     record.TotalTakes,
+		},
+		Error:      record.Error,
+		Code:       record.ErrorCode,
+		DialogueID: record.DialogueID,
+		CreatedAt:  record.CreatedAt,
+		StartedAt:  record.StartedAt,
+		FinishedAt: record.FinishedAt,
+	}
+	if len(record.Result) > 0 {
+		if err := json.Unmarshal(record.Result, &job.Result); err != nil {
+			return nil, fiber.StatusInternalServerError, errors.New("Failed to read job result")
+		}
+	}
+	return job, 0, nil
+}
+
+// notify wakes an idle worker, if there is one.
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel stops a job if it is running in this process. Other processes notice
+// at their next heartbeat.
+func (q *jobQueue) cancel(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cancel, ok := q.running[id]; ok {
+		cancel()
+	}
+}
+
+// requeueExpired queues orphaned jobs again and returns how many there were.
+func (q *jobQueue) requeueExpired() (int64, error) {
+	n, err := db.RequeueExpiredGenerationJobs(jobLease)
+	if n > 0 {
+		log.Printf("Requeued %d interrupted generation jobs", n)
+	}
+	return n, err
+}
+
+// reap periodically requeues jobs orphaned by processes that stopped.
+func (q *jobQueue) reap() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := q.requeueExpired()
+		if err != nil {
+			log.Printf("Failed to requeue interrupted jobs: %v", err)
+			continue
+		}
+		for ; n > 0; n-- {
+			q.notify()
+		}
+	}
+}
+
+// heartbeat renews a running job's lease until ctx is done, and cancels the
+// job once it is no longer running under this claim.
+func (q *jobQueue) heartbeat(ctx context.Context, cancel context.CancelFunc, job *db.GenerationJob) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		running, err := db.HeartbeatGenerationJob(job.ID, *job.StartedAt)
+		if err != nil {
+			log.Printf("Failed to renew lease of job %d: %v", job.ID, err)
+			continue
+		}
+		if !running {
+			cancel()
+			return
+		}
+	}
+}
+
+// work claims and runs jobs until the process exits.
+func (q *jobQueue) work() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := db.ClaimGenerationJob()
+		if err == nil {
+			q.run(job)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to claim generation job: %v", err)
+		}
+
+		select {
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// run generates a claimed job's dialogue and records the outcome.
+func (q *jobQueue) run(job *db.GenerationJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		q.mu.Unlock()
+	}()
+	go q.heartbeat(ctx, cancel, job)
+
+	var req DialogueRequest
+	if err := json.Unmarshal(job.Request, &req); err != nil {
+		q.fail(job.ID, fmt.Errorf("invalid job request: %w", err))
+		return
+	}
+
+	var completed atomic.Int32
+	ctx = withTakeProgress(ctx, func() {
+		if err := db.UpdateGenerationJobProgress(job.ID, int(completed.Add(1))); err != nil {
+			log.Printf("Failed to update progress of job %d: %v", job.ID, err)
+		}
+	})
+
+	dialogue, err := cachedDialogue(ctx, req, true, true)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Printf("Generation job %d canceled or requeued", job.ID)
+		return
+	}
+	if err != nil {
+		q.fail(job.ID, err)
+		return
+	}
+	recordUsage(jobsEndpoint, job.APIKey, dialogue.Usage)
+
+	var dialogueID *int
+	if job.AutoSave {
+		id, err := saveDialogue(req, dialogue.Exchanges)
+		if err != nil {
+			q.fail(job.ID, fmt.Errorf("failed to save dialogue: %w", err))
+			return
+		}
+		dialogueID = &id
+	}
+
+	result, err := json.Marshal(dialogue)
+	if err == nil {
+		err = db.CompleteGenerationJob(job.ID, result, dialogueID)
+	}
+	if err != nil {
+		log.Printf("Failed to record result of job %d: %v", job.ID, err)
+	}
+}
+
+func (q *jobQueue) fail(id int, err error) {
+	_, code, _ := generationFailure(err)
+	if err := db.FailGenerationJob(id, err.Error(), code); err != nil {
+		log.Printf("Failed to record failure of job %d: %v", id, err)
+	}
+}
+
+// saveDialogue stores a generated dialogue like SaveDialogue does.
+func saveDialogue(req DialogueRequest, exchanges []DialogueExchange) (int, error) {
+	charactersJSON, err := json.Marshal(req.Characters)
+	if err != nil {
+		return 0, err
+	}
+	exchangesJSON, err := json.Marshal(exchanges)
+	if err != nil {
+		return 0, err
+	}
+	return db.SaveGeneratedDialogue(req.Scenario, charactersJSON, exchangesJSON)
+}
+
+type takeProgressKey struct{}
+
+// withTakeProgress returns a context under which onTake is called each time
+// a take is generated successfully. Failed takes are not counted.
+func withTakeProgress(ctx context.Context, onTake func()) context.Context {
+	return context.WithValue(ctx, takeProgressKey{}, onTake)
+}
+
+func reportTake(ctx context.Context) {
+	if onTake, ok := ctx.Value(takeProgressKey{}).(func()); ok {
+		onTake()
+	}
+}
@@ -0,0 +1,159 @@
+// api/jsonmode.go
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// Output formats a DialogueRequest can ask the model for
+const (
+	formatLines = "lines"
+	formatJSON  = "json"
+)
+
+// dialogueJSONSchema is shown to the model in JSON mode and enforced by
+// validateJSONExchanges.
+const dialogueJSONSchema = `{
+  "type": "array",
+  "minItems": 1,
+  "items": {
+    "type": "object",
+    "required": ["line"],
+    "properties": {
+      "type": {"enum": ["dialogue", "action", "transition"], "default": "dialogue"},
+      "character": {"type": "string", "description": "exact name of a listed character; required for dialogue"},
+      "parenthetical": {"type": "string", "description": "optional delivery cue such as whispering"},
+      "line": {"type": "string", "minLength": 1, "description": "the spoken line, stage direction or transition"},
+      "emotion": {"type": "string", "description": "optional emotion of a dialogue line"},
+      "intensity": {"type": "number", "minimum": 0, "maximum": 1}
+    }
+  }
+}`
+
+var (
+	codeFencePattern     = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*(.*?)\\s*```")
+	trailingCommaPattern = regexp.MustCompile(`,\s*([\]}])`)
+)
+
+// exchangesFromJSON parses a JSON-mode completion. When the reply fails
+// validation even after repair, the model is asked once more with the
+// validation error; if that reply fails too, the line parser takes over.
+func exchangesFromJSON(ctx context.Context, llmReq llm.Request, text string, characters []CharacterRequest) ([]DialogueExchange, []RejectedLine, error) {
+	exchanges, err := parseJSONExchanges(text, characters)
+	if err == nil {
+		return exchanges, nil, nil
+	}
+	log.Printf("JSON dialogue rejected, retrying: %v", err)
+
+	retry := llmReq
+	retry.Prompt = fmt.Sprintf("%s\nYour previous reply was:\n%s\n\nIt was rejected because: %v\nReply again with only the corrected JSON array.\n",
+		llmReq.Prompt, text, err)
+
+	resp, err := callProvider(ctx, retry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exchanges, err = parseJSONExchanges(resp.Text, characters)
+	if err == nil {
+		return exchanges, nil, nil
+	}
+	log.Printf("JSON dialogue rejected after retry, falling back to line parser: %v", err)
+
+	if exchanges, rejected := parseDialogueResponse(resp.Text, characters); len(exchanges) > 0 {
+		return exchanges, rejected, nil
+	}
+	exchanges, rejected := parseDialogueResponse(text, characters)
+	return exchanges, rejected, nil
+}
+
+// parseJSONExchanges repairs, decodes and validates a JSON array of exchanges.
+func parseJSONExchanges(text string, characters []CharacterRequest) ([]DialogueExchange, error) {
+	var items []map[string]interface{}
+	if err := json.Unmarshal([]byte(repairJSON(text)), &items); err != nil {
+		return nil, fmt.Errorf("reply is not a JSON array of objects: %v", err)
+	}
+	return validateJSONExchanges(items, characters)
+}
+
+// repairJSON applies tolerant fixes for common model mistakes: markdown code
+// fences, prose around the array and trailing commas.
+func repairJSON(text string) string {
+	text = strings.TrimSpace(text)
+
+	if m := codeFencePattern.FindStringSubmatch(text); m != nil {
+		text = m[1]
+	}
+
+	if start, end := strings.Index(text, "["), strings.LastIndex(text, "]"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+
+	return trailingCommaPattern.ReplaceAllString(text, "$1")
+}
+
+// validateJSONExchanges checks decoded items against dialogueJSONSchema.
+func validateJSONExchanges(items []map[string]interface{}, characters []CharacterRequest) ([]DialogueExchange, error) {
+	if len(items) == 0 {
+		return nil, errors.New("the array must contain at least one exchange")
+	}
+
+	cast := newCast(characters)
+	exchanges := make([]DialogueExchange, 0, len(items))
+	for i, item := range items {
+		line, ok := item["line"].(string)
+		if !ok || strings.TrimSpace(line) == "" {
+			return nil, fmt.Errorf(`item %d: "line" must be a non-empty string`, i)
+		}
+
+		beat := beatDialogue
+		if t, present := item["type"]; present {
+			beat, _ = t.(string)
+		}
+		switch beat {
+		case beatAction, beatTransition:
+			exchanges = append(exchanges, DialogueExchange{
+				Type: beat,
+				Line: strings.TrimSpace(line),
+			})
+			continue
+		case beatDialogue:
+		default:
+			return nil, fmt.Errorf(`item %d: "type" must be "dialogue", "action" or "transition"`, i)
+		}
+
+		character, ok := item["character"].(string)
+		if !ok || strings.TrimSpace(character) == "" {
+			return nil, fmt.Errorf(`item %d: "character" must be a non-empty string`, i)
+		}
+		name, ok := cast.resolve(character)
+		if !ok {
+			return nil, fmt.Errorf(`item %d: %q is not one of the listed characters`, i, character)
+		}
+		parenthetical, _ := item["parenthetical"].(string)
+		emotion, _ := item["emotion"].(string)
+		intensity, hasIntensity := item["intensity"].(float64)
+		if emotion != "" && !hasIntensity {
+			intensity = 0.5
+		}
+
+		exchanges = append(exchanges, DialogueExchange{
+			Type:          beatDialogue,
+			Character:     name,
+			Line:          strings.TrimSpace(line),
+			Parenthetical: strings.Trim(strings.TrimSpace(parenthetical), "()"),
+			Emotion:       normalizeEmotion(emotion),
+			Intensity:     clampUnit(intensity),
+		})
+	}
+
+	return exchanges, nil
+}
@@ -0,0 +1,399 @@
+// api/parser.go
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// RejectedLine is a line of model output the parser could not use.
+type RejectedLine struct {
+	Number int    `json:"number"` // 1-based line number in the completion
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+var (
+	listMarkerPattern    = regexp.MustCompile(`^(?:\d+[.)]|[-*•>])\s+`)
+	parentheticalPattern = regexp.MustCompile(`^\(([^)]*)\)\s*`)
+	speakerParenPattern  = regexp.MustCompile(`^(.*?)\s*\(([^)]*)\)$`)
+	transitionPattern    = regexp.MustCompile(`^(?:(?:(?:SMASH|MATCH|JUMP|HARD|FLASH) )?CUT TO:|CUT TO BLACK[.:]?|(?:MATCH )?DISSOLVE TO:|WIPE TO:|BACK TO:|TIME CUT:|INTERCUT(?: WITH)?:|IRIS (?:IN|OUT)[.:]?|FADE (?:IN|OUT)[.:]?|FADE TO(?: BLACK| WHITE)?[.:])$`)
+	actionPattern        = regexp.MustCompile(`^(?:\[(.+)\]|\*(.+)\*|\((.+)\)|(?i:action):\s*(.+))$`)
+)
+
+// maxSpeakerWords bounds how long a "NAME:" prefix can be before the colon is
+// treated as part of the dialogue instead
+const maxSpeakerWords = 5
+
+// dialogueParser turns screenplay-style model output into exchanges. It reads
+// one line at a time so streamed output can be parsed as it arrives.
+//
+// It understands "NAME: line", markdown bold or list markers around names,
+// parentheticals before or after the name, quoted lines, a speaker name alone
+// on its own line followed by the speech, and continuation lines that extend
+// the previous speech. Stage directions in brackets, asterisks or their own
+// parentheses become action beats, and lines like "CUT TO:" become
+// transitions. Speaker names are resolved to the canonical character names
+// through cast.
+type dialogueParser struct {
+	cast        *cast
+	lineNo      int
+	pending     *DialogueExchange
+	pendingLine int
+	rejected    []RejectedLine
+}
+
+func newDialogueParser(characters []CharacterRequest) *dialogueParser {
+	return &dialogueParser{cast: newCast(characters)}
+}
+
+// parseDialogueResponse parses a complete model response.
+func parseDialogueResponse(aiResponse string, characters []CharacterRequest) ([]DialogueExchange, []RejectedLine) {
+	p := newDialogueParser(characters)
+
+	var exchanges []DialogueExchange
+	for _, line := range strings.Split(aiResponse, "\n") {
+		exchanges = append(exchanges, p.Feed(line)...)
+	}
+	exchanges = append(exchanges, p.Close()...)
+
+	return exchanges, p.Rejected()
+}
+
+// Feed parses the next line and returns any exchanges it completed. A speech
+// is complete once a following line shows it cannot continue.
+func (p *dialogueParser) Feed(raw string) []DialogueExchange {
+	p.lineNo++
+
+	line := cleanLine(raw)
+	if line == "" {
+		// A blank line ends the current speech
+		return p.flush()
+	}
+
+	if transitionPattern.MatchString(line) {
+		return append(p.flush(), DialogueExchange{Type: beatTransition, Line: line})
+	}
+
+	// A parenthetical straight after a speaker cue is that speech's delivery
+	// cue; anywhere else a line in parentheses is a stage direction
+	awaitingCue := p.pending != nil && p.pending.Line == "" && p.pending.Parenthetical == ""
+	if m := actionPattern.FindStringSubmatch(line); m != nil && !(awaitingCue && strings.HasPrefix(line, "(")) {
+		return append(p.flush(), DialogueExchange{Type: beatAction, Line: firstNonEmpty(m[1:])})
+	}
+
+	if speaker, parenthetical, text, ok := p.splitSpeaker(line); ok {
+		completed := p.flush()
+		p.pending = &DialogueExchange{
+			Type:          beatDialogue,
+			Character:     speaker,
+			Line:          text,
+			Parenthetical: parenthetical,
+		}
+		p.pendingLine = p.lineNo
+		return completed
+	}
+
+	if p.pending != nil {
+		p.continueSpeech(line)
+		return nil
+	}
+
+	p.reject(p.lineNo, raw, p.rejectReason(line))
+	return nil
+}
+
+// Close flushes the final speech.
+func (p *dialogueParser) Close() []DialogueExchange {
+	return p.flush()
+}
+
+// Rejected lists the lines that could not be used so far.
+func (p *dialogueParser) Rejected() []RejectedLine {
+	return p.rejected
+}
+
+// Pending returns the speech in progress as it would be completed now, if it
+// has any dialogue yet. Later lines may still extend it.
+func (p *dialogueParser) Pending() (DialogueExchange, bool) {
+	if p.pending == nil {
+		return DialogueExchange{}, false
+	}
+	return finishSpeech(*p.pending)
+}
+
+func (p *dialogueParser) flush() []DialogueExchange {
+	if p.pending == nil {
+		return nil
+	}
+	exchange, ok := finishSpeech(*p.pending)
+	p.pending = nil
+
+	if !ok {
+		p.reject(p.pendingLine, exchange.Character, "speaker has no dialogue")
+		return nil
+	}
+	return []DialogueExchange{exchange}
+}
+
+// finishSpeech pulls the emotion tag out of a speech and unquotes it,
+// reporting whether any dialogue is left.
+func finishSpeech(exchange DialogueExchange) (DialogueExchange, bool) {
+	line, emotion, intensity := extractEmotionTag(exchange.Line)
+	exchange.Line = unquote(line)
+	exchange.Emotion, exchange.Intensity = emotion, intensity
+	return exchange, exchange.Line != ""
+}
+
+// continueSpeech adds a line to the pending speech, treating a leading
+// parenthetical as the delivery cue when the speech has none yet.
+func (p *dialogueParser) continueSpeech(line string) {
+	if p.pending.Line == "" && p.pending.Parenthetical == "" {
+		if m := parentheticalPattern.FindStringSubmatch(line); m != nil {
+			p.pending.Parenthetical = strings.TrimSpace(m[1])
+			line = strings.TrimSpace(line[len(m[0]):])
+		}
+	}
+	if line == "" {
+		return
+	}
+	if p.pending.Line == "" {
+		p.pending.Line = line
+		return
+	}
+	p.pending.Line += " " + line
+}
+
+// splitSpeaker recognises "NAME: text", "NAME (cue): text" and a bare
+// "NAME" line that introduces a speech on the following lines.
+func (p *dialogueParser) splitSpeaker(line string) (speaker, parenthetical, text string, ok bool) {
+	name, rest, hasColon := strings.Cut(line, ":")
+	if !hasColon {
+		name = line
+	}
+
+	name = strings.TrimSpace(name)
+	if m := speakerParenPattern.FindStringSubmatch(name); m != nil {
+		name, parenthetical = m[1], strings.TrimSpace(m[2])
+	}
+	if name == "" || len(strings.Fields(name)) > maxSpeakerWords {
+		return "", "", "", false
+	}
+
+	speaker, ok = p.cast.resolve(name)
+	if !ok {
+		return "", "", "", false
+	}
+	// A bare line only counts as a speaker when it is nothing but the name
+	if !hasColon && parenthetical == "" && !isCueName(line) {
+		return "", "", "", false
+	}
+
+	text = strings.TrimSpace(rest)
+	if m := parentheticalPattern.FindStringSubmatch(text); m != nil && parenthetical == "" {
+		parenthetical = strings.TrimSpace(m[1])
+		text = strings.TrimSpace(text[len(m[0]):])
+	}
+	return speaker, parenthetical, text, true
+}
+
+func (p *dialogueParser) rejectReason(line string) string {
+	if name, _, ok := strings.Cut(line, ":"); ok && len(strings.Fields(name)) <= maxSpeakerWords {
+		return fmt.Sprintf("unknown speaker %q", strings.TrimSpace(name))
+	}
+	return "no speaker"
+}
+
+func (p *dialogueParser) reject(lineNo int, text, reason string) {
+	p.rejected = append(p.rejected, RejectedLine{
+		Number: lineNo,
+		Text:   strings.TrimSpace(text),
+		Reason: reason,
+	})
+}
+
+// cleanLine strips list numbering, bullets and markdown emphasis.
+func cleanLine(line string) string {
+	line = strings.TrimSpace(line)
+	line = listMarkerPattern.ReplaceAllString(line, "")
+	line = strings.ReplaceAll(line, "**", "")
+	line = strings.ReplaceAll(line, "__", "")
+	return strings.TrimSpace(line)
+}
+
+// isCueName reports whether a line without a colon looks like a screenplay
+// character cue: a short, upper-case name alone on its line.
+func isCueName(line string) bool {
+	return strings.ToUpper(line) == line && strings.IndexFunc(line, unicode.IsLetter) >= 0
+}
+
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func unquote(text string) string {
+	text = strings.TrimSpace(text)
+	for _, q := range [][2]string{{`"`, `"`}, {"“", "”"}, {"'", "'"}} {
+		if len(text) >= len(q[0])+len(q[1]) && strings.HasPrefix(text, q[0]) && strings.HasSuffix(text, q[1]) {
+			inner := text[len(q[0]) : len(text)-len(q[1])]
+			// Leave lines like "Yes," she said, "now." alone
+			if !strings.Contains(inner, q[0]) && !strings.Contains(inner, q[1]) {
+				return strings.TrimSpace(inner)
+			}
+		}
+	}
+	return text
+}
+
+// cast resolves the speaker names a model writes to canonical character names.
+type cast struct {
+	names []string
+	keys  [][]string // normalized name and aliases for each character
+}
+
+func newCast(characters []CharacterRequest) *cast {
+	c := &cast{}
+	for _, char := range characters {
+		keys := []string{normalizeName(char.Name)}
+		for _, alias := range char.Aliases {
+			if key := normalizeName(alias); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		c.names = append(c.names, char.Name)
+		c.keys = append(c.keys, keys)
+	}
+	return c
+}
+
+// resolve maps a speaker name to a character name. It tries, in order, an
+// exact name or alias match, a match on a subset of the name's words (so
+// "Smith" finds "Detective Smith"), and a small edit distance to catch typos.
+// Ambiguous names do not resolve.
+func (c *cast) resolve(raw string) (string, bool) {
+	name := normalizeName(raw)
+	if name == "" {
+		return "", false
+	}
+
+	if i, ok := c.unique(func(key string) bool { return key == name }); ok {
+		return c.names[i], true
+	}
+	if i, ok := c.unique(func(key string) bool { return wordsSubset(name, key) }); ok {
+		return c.names[i], true
+	}
+	if i, ok := c.unique(func(key string) bool { return closeMatch(name, key) }); ok {
+		return c.names[i], true
+	}
+	return "", false
+}
+
+// unique returns the only character with a key matching match.
+func (c *cast) unique(match func(key string) bool) (int, bool) {
+	found := -1
+	for i, keys := range c.keys {
+		for _, key := range keys {
+			if match(key) {
+				if found >= 0 && found != i {
+					return 0, false
+				}
+				found = i
+				break
+			}
+		}
+	}
+	return found, found >= 0
+}
+
+// normalizeName lowercases a name and drops punctuation and markdown.
+func normalizeName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			sb.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-' || r == '.':
+			sb.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// wordsSubset reports whether every word of name appears in key.
+func wordsSubset(name, key string) bool {
+	keyWords := strings.Fields(key)
+	for _, word := range strings.Fields(name) {
+		found := false
+		for _, kw := range keyWords {
+			if kw == word {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// minTypoLength is the shortest name or word a typo is tolerated in; shorter
+// ones are too close to ordinary words, as "Note" is to "Nate"
+const minTypoLength = 5
+
+// closeMatch allows roughly one typo per five characters, against the whole
+// key or any of its longer words.
+func closeMatch(name, key string) bool {
+	within := func(a, b string) bool {
+		n := len([]rune(b))
+		if n < minTypoLength {
+			return false
+		}
+		return editDistance(a, b) <= n/minTypoLength
+	}
+
+	if within(name, key) {
+		return true
+	}
+	for _, word := range strings.Fields(key) {
+		if within(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance is the optimal string alignment distance: insertions,
+// deletions, substitutions and swaps of adjacent letters each cost one.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
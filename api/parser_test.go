@@ -0,0 +1,181 @@
+package api
+
+import (
+	"slices"
+	"testing"
+)
+
+var parserCast = []CharacterRequest{
+	{Name: "Detective Smith"},
+	{Name: "Anna Reyes"},
+	{Name: "Marcus Webb", Aliases: []string{"Doc"}},
+}
+
+func say(character, line string) DialogueExchange {
+	return DialogueExchange{Type: beatDialogue, Character: character, Line: line}
+}
+
+func sayCue(character, parenthetical, line string) DialogueExchange {
+	return DialogueExchange{Type: beatDialogue, Character: character, Line: line, Parenthetical: parenthetical}
+}
+
+func TestParseDialogueResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		characters []CharacterRequest // parserCast when nil
+		input      string
+		want       []DialogueExchange
+		rejected   []RejectedLine
+	}{
+		{
+			name:  "plain",
+			input: "Detective Smith: Where were you?\nAnna Reyes: Home.",
+			want:  []DialogueExchange{say("Detective Smith", "Where were you?"), say("Anna Reyes", "Home.")},
+		},
+		{
+			name:  "bold names",
+			input: "**Detective Smith:** Where were you?\n**Anna Reyes**: Home.",
+			want:  []DialogueExchange{say("Detective Smith", "Where were you?"), say("Anna Reyes", "Home.")},
+		},
+		{
+			name:  "numbered and bulleted",
+			input: "1. Detective Smith: Where were you?\n2) Anna Reyes: Home.\n- Marcus Webb: She was.",
+			want: []DialogueExchange{
+				say("Detective Smith", "Where were you?"),
+				say("Anna Reyes", "Home."),
+				say("Marcus Webb", "She was."),
+			},
+		},
+		{
+			name:  "upper-case names",
+			input: "DETECTIVE SMITH: Where were you?\nANNA REYES: Home.",
+			want:  []DialogueExchange{say("Detective Smith", "Where were you?"), say("Anna Reyes", "Home.")},
+		},
+		{
+			name:  "partial name",
+			input: "Smith: Where were you?\nAnna: Home.",
+			want:  []DialogueExchange{say("Detective Smith", "Where were you?"), say("Anna Reyes", "Home.")},
+		},
+		{
+			name:  "alias",
+			input: "Doc: She was with me.",
+			want:  []DialogueExchange{say("Marcus Webb", "She was with me.")},
+		},
+		{
+			name:  "typos",
+			input: "Detectve Smith: Where were you?\nSmtih: Answer me.",
+			want:  []DialogueExchange{say("Detective Smith", "Where were you?"), say("Detective Smith", "Answer me.")},
+		},
+		{
+			name:       "no typos in short names",
+			characters: []CharacterRequest{{Name: "Nate"}, {Name: "Anna Reyes"}},
+			input:      "Nate: Well?\n\nNote: he is lying.\n\nAnn: Home.",
+			want:       []DialogueExchange{say("Nate", "Well?")},
+			rejected: []RejectedLine{
+				{Number: 3, Text: "Note: he is lying.", Reason: `unknown speaker "Note"`},
+				{Number: 5, Text: "Ann: Home.", Reason: `unknown speaker "Ann"`},
+			},
+		},
+		{
+			name:       "ambiguous partial name",
+			characters: []CharacterRequest{{Name: "Detective Smith"}, {Name: "Agent Smith"}},
+			input:      "Smith: Freeze.\nAgent Smith: You heard him.",
+			want:       []DialogueExchange{say("Agent Smith", "You heard him.")},
+			rejected:   []RejectedLine{{Number: 1, Text: "Smith: Freeze.", Reason: `unknown speaker "Smith"`}},
+		},
+		{
+			name:  "multi-line speech",
+			input: "Anna: I was home.\nAll night.\n\nDetective Smith: Alone?",
+			want:  []DialogueExchange{say("Anna Reyes", "I was home. All night."), say("Detective Smith", "Alone?")},
+		},
+		{
+			name:  "cue on its own line",
+			input: "ANNA REYES\n(quietly)\nI was home.\nAll night.",
+			want:  []DialogueExchange{sayCue("Anna Reyes", "quietly", "I was home. All night.")},
+		},
+		{
+			name:  "parenthetical before colon",
+			input: "Anna (quietly): I was home.",
+			want:  []DialogueExchange{sayCue("Anna Reyes", "quietly", "I was home.")},
+		},
+		{
+			name:  "parenthetical after colon",
+			input: "Anna: (quietly) I was home.",
+			want:  []DialogueExchange{sayCue("Anna Reyes", "quietly", "I was home.")},
+		},
+		{
+			name:  "quoted lines",
+			input: "Anna: \"I was home.\"\nSmith: “Sure.”\nDoc: \"Yes,\" she said, \"now.\"",
+			want: []DialogueExchange{
+				say("Anna Reyes", "I was home."),
+				say("Detective Smith", "Sure."),
+				say("Marcus Webb", `"Yes," she said, "now."`),
+			},
+		},
+		{
+			name:  "colon inside speech",
+			input: "Detective Smith: At 3:00 he left",
+			want:  []DialogueExchange{say("Detective Smith", "At 3:00 he left")},
+		},
+		{
+			name:  "actions and transitions",
+			input: "[Smith leans in]\nSmith: Talk.\n(Anna looks away.)\n*pause*\nAction: The phone rings.\nCUT TO:",
+			want: []DialogueExchange{
+				{Type: beatAction, Line: "Smith leans in"},
+				say("Detective Smith", "Talk."),
+				{Type: beatAction, Line: "Anna looks away."},
+				{Type: beatAction, Line: "pause"},
+				{Type: beatAction, Line: "The phone rings."},
+				{Type: beatTransition, Line: "CUT TO:"},
+			},
+		},
+		{
+			name:       "cue names that end like transitions",
+			characters: []CharacterRequest{{Name: "Justin"}, {Name: "Otto"}},
+			input:      "FADE IN:\nJUSTIN:\nI was there.\n\nOTTO:\nSo was I.\nSMASH CUT TO:",
+			want: []DialogueExchange{
+				{Type: beatTransition, Line: "FADE IN:"},
+				say("Justin", "I was there."),
+				say("Otto", "So was I."),
+				{Type: beatTransition, Line: "SMASH CUT TO:"},
+			},
+		},
+		{
+			name:  "unknown speaker",
+			input: "Narrator: It was a dark night.\nAnna: Home.",
+			want:  []DialogueExchange{say("Anna Reyes", "Home.")},
+			rejected: []RejectedLine{
+				{Number: 1, Text: "Narrator: It was a dark night.", Reason: `unknown speaker "Narrator"`},
+			},
+		},
+		{
+			name:     "no speaker",
+			input:    "Here is your dialogue.\n\nAnna: Home.",
+			want:     []DialogueExchange{say("Anna Reyes", "Home.")},
+			rejected: []RejectedLine{{Number: 1, Text: "Here is your dialogue.", Reason: "no speaker"}},
+		},
+		{
+			name:     "speaker has no dialogue",
+			input:    "Anna:\n\nSmith: Well?",
+			want:     []DialogueExchange{say("Detective Smith", "Well?")},
+			rejected: []RejectedLine{{Number: 1, Text: "Anna Reyes", Reason: "speaker has no dialogue"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			characters := tt.characters
+			if characters == nil {
+				characters = parserCast
+			}
+
+			got, rejected := parseDialogueResponse(tt.input, characters)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("exchanges:\n got %+v\nwant %+v", got, tt.want)
+			}
+			if !slices.Equal(rejected, tt.rejected) {
+				t.Errorf("rejected:\n got %+v\nwant %+v", rejected, tt.rejected)
+			}
+		})
+	}
+}
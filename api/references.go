@@ -0,0 +1,204 @@
+// api/references.go
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/search"
+)
+
+const (
+	defaultReferenceLimit       = 3
+	defaultReferenceTokenBudget = 600
+)
+
+// ReferenceOptions asks for reference dialogues to be used as few-shot style
+// examples, chosen by tag, by similarity to the scenario, or both.
+type ReferenceOptions struct {
+	Tags        []string `json:"tags"`
+	Similar     bool     `json:"similar"`
+	Limit       int      `json:"limit"`
+	TokenBudget int      `json:"tokenBudget"`
+}
+
+// ReferenceSearchResult is a reference dialogue with its relevance score.
+type ReferenceSearchResult struct {
+	db.ReferenceDialogue
+	Score float64 `json:"score"`
+}
+
+// The lexical index over reference dialogue content. It is built on first use
+// and rebuilt whenever a reference is added.
+var (
+	referenceIndexMu sync.RWMutex
+	referenceIndex   *search.Index
+	referenceDocs    map[int]db.ReferenceDialogue
+)
+
+// rebuildReferenceIndex reloads every reference dialogue and reindexes it.
+func rebuildReferenceIndex() error {
+	dialogues, err := db.GetReferenceDialogues("")
+	if err != nil {
+		return err
+	}
+
+	docs := make([]search.Document, len(dialogues))
+	byID := make(map[int]db.ReferenceDialogue, len(dialogues))
+	for i, d := range dialogues {
+		docs[i] = search.Document{ID: d.ID, Text: d.Content}
+		byID[d.ID] = d
+	}
+	index := search.NewIndex(docs)
+
+	referenceIndexMu.Lock()
+	referenceIndex = index
+	referenceDocs = byID
+	referenceIndexMu.Unlock()
+
+	return nil
+}
+
+// searchReferences ranks reference dialogues against query, best first.
+// A limit of zero or less returns every match.
+func searchReferences(query string, limit int) ([]ReferenceSearchResult, error) {
+	referenceIndexMu.RLock()
+	built := referenceIndex != nil
+	referenceIndexMu.RUnlock()
+
+	if !built {
+		if err := rebuildReferenceIndex(); err != nil {
+			return nil, err
+		}
+	}
+
+	referenceIndexMu.RLock()
+	defer referenceIndexMu.RUnlock()
+
+	hits := referenceIndex.Search(query, limit)
+	results := make([]ReferenceSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, ReferenceSearchResult{
+			ReferenceDialogue: referenceDocs[hit.ID],
+			Score:             hit.Score,
+		})
+	}
+	return results, nil
+}
+
+// selectReferences picks the reference dialogues to show the model for req,
+// best match first, keeping their combined size within the token budget.
+// Tag matches rank first, then lexical similarity to the scenario.
+func selectReferences(req DialogueRequest) ([]db.ReferenceDialogue, error) {
+	opts := req.References
+	if opts == nil || (len(opts.Tags) == 0 && !opts.Similar) {
+		return nil, nil
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultReferenceLimit
+	}
+	budget := opts.TokenBudget
+	if budget <= 0 {
+		budget = defaultReferenceTokenBudget
+	}
+
+	similarity := make(map[int]float64)
+	var candidates []db.ReferenceDialogue
+	if opts.Similar {
+		results, err := searchReferences(referenceQuery(req), 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search reference dialogues: %w", err)
+		}
+		for _, r := range results {
+			similarity[r.ID] = r.Score
+			if len(opts.Tags) == 0 {
+				candidates = append(candidates, r.ReferenceDialogue)
+			}
+		}
+	}
+	if len(opts.Tags) > 0 {
+		var err error
+		candidates, err = referenceCandidates(opts.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch reference dialogues: %w", err)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ti := countMatchingTags(candidates[i].Tags, opts.Tags)
+		tj := countMatchingTags(candidates[j].Tags, opts.Tags)
+		if ti != tj {
+			return ti > tj
+		}
+		return similarity[candidates[i].ID] > similarity[candidates[j].ID]
+	})
+
+	var selected []db.ReferenceDialogue
+	for _, d := range candidates {
+		if len(selected) == limit {
+			break
+		}
+		cost := llm.EstimateTokens(d.Content)
+		if cost > budget {
+			continue
+		}
+		budget -= cost
+		selected = append(selected, d)
+	}
+
+	return selected, nil
+}
+
+// referenceCandidates loads references carrying any of tags.
+func referenceCandidates(tags []string) ([]db.ReferenceDialogue, error) {
+	seen := make(map[int]bool)
+	var candidates []db.ReferenceDialogue
+	for _, tag := range tags {
+		dialogues, err := db.GetReferenceDialogues(tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dialogues {
+			if !seen[d.ID] {
+				seen[d.ID] = true
+				candidates = append(candidates, d)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// referenceQuery is the text a reference should resemble.
+func referenceQuery(req DialogueRequest) string {
+	return strings.Join([]string{req.Scenario, req.Style, req.EmotionalTone}, " ")
+}
+
+func countMatchingTags(have, want []string) int {
+	count := 0
+	for _, w := range want {
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+func referenceIDs(references []db.ReferenceDialogue) []int {
+	if len(references) == 0 {
+		return nil
+	}
+	ids := make([]int, len(references))
+	for i, d := range references {
+		ids[i] = d.ID
+	}
+	return ids
+}
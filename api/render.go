@@ -0,0 +1,302 @@
+// api/render.go
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/tts"
+)
+
+// renderTimeout bounds voicing a whole dialogue
+const renderTimeout = 5 * time.Minute
+
+// Defaults for dialogue renders; RENDER_MAX_CONCURRENCY overrides the cap on
+// lines voiced at once
+const (
+	defaultRenderGapMS       = 500
+	maxRenderGapMS           = 10000
+	defaultRenderConcurrency = 4
+)
+
+// RenderOptions control how a dialogue is voiced.
+type RenderOptions struct {
+	// Provider voices every line instead of each character's own provider
+	Provider string `json:"provider,omitempty"`
+	// GapMS is the silence between lines in milliseconds (default 500)
+	GapMS *int `json:"gapMs,omitempty"`
+	// Concurrency is how many lines are voiced at once
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// RenderDialogueRequest is a generated dialogue to voice. Characters, when
+// given, supply types for speakers that are not saved characters.
+type RenderDialogueRequest struct {
+	DialogueResponse
+	Characters []CharacterRequest `json:"characters,omitempty"`
+	RenderOptions
+}
+
+// RenderedDialogue is a voiced dialogue: one WAV track and where each line
+// falls in it.
+type RenderedDialogue struct {
+	// Audio is the WAV file, base64-encoded in JSON
+	Audio       []byte         `json:"audio"`
+	ContentType string         `json:"contentType"`
+	SampleRate  int            `json:"sampleRate"`
+	DurationMS  int            `json:"durationMs"`
+	Manifest    []RenderedLine `json:"manifest"`
+}
+
+// RenderedLine places one voiced exchange in the track.
+type RenderedLine struct {
+	// Index is the exchange's position in the dialogue; beats without
+	// speech are not voiced and have no entry
+	Index     int    `json:"index"`
+	Character string `json:"character"`
+	Line      string `json:"line"`
+	StartMS   int    `json:"startMs"`
+	EndMS     int    `json:"endMs"`
+	Provider  string `json:"provider"`
+	VoiceID   string `json:"voiceId"`
+}
+
+// RenderDialogue voices an inline dialogue into a single audio track.
+func RenderDialogue(c *fiber.Ctx) error {
+	var req RenderDialogueRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	return renderDialogue(c, req.Exchanges, req.Characters, req.RenderOptions)
+}
+
+// RenderSavedDialogue voices a saved dialogue into a single audio track.
+func RenderSavedDialogue(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dialogue ID",
+		})
+	}
+
+	var opts RenderOptions
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&opts); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	saved, status, err := loadSavedScene(id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return renderDialogue(c, saved.Exchanges, saved.Characters, opts)
+}
+
+func renderDialogue(c *fiber.Ctx, exchanges []DialogueExchange, characters []CharacterRequest, opts RenderOptions) error {
+	defaultBeatTypes(exchanges)
+
+	gapMS, concurrency, msg := renderLimits(opts)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	lines, status, err := voiceLines(exchanges, characters, opts.Provider)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), renderTimeout)
+	defer cancel()
+
+	rendered, err := renderLines(ctx, lines, gapMS, concurrency)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(rendered)
+}
+
+// renderLimits reads the gap and concurrency, capped by the server's limits.
+func renderLimits(opts RenderOptions) (gapMS, concurrency int, msg string) {
+	maxConcurrency := defaultRenderConcurrency
+	if v, err := strconv.Atoi(os.Getenv("RENDER_MAX_CONCURRENCY")); err == nil && v > 0 {
+		maxConcurrency = v
+	}
+
+	gapMS, concurrency = defaultRenderGapMS, maxConcurrency
+	if opts.GapMS != nil {
+		if *opts.GapMS < 0 || *opts.GapMS > maxRenderGapMS {
+			return 0, 0, fmt.Sprintf("Gap must be between 0 and %d milliseconds", maxRenderGapMS)
+		}
+		gapMS = *opts.GapMS
+	}
+	if opts.Concurrency != 0 {
+		if opts.Concurrency < 1 || opts.Concurrency > maxConcurrency {
+			return 0, 0, fmt.Sprintf("Concurrency must be between 1 and %d", maxConcurrency)
+		}
+		concurrency = opts.Concurrency
+	}
+	return gapMS, concurrency, ""
+}
+
+// voiceLine is one exchange to voice.
+type voiceLine struct {
+	index    int
+	exchange DialogueExchange
+	voice    *voice
+}
+
+// voiceLines picks out the spoken exchanges and resolves each speaker's voice
+// once, returning an HTTP status to use on error.
+func voiceLines(exchanges []DialogueExchange, characters []CharacterRequest, provider string) ([]voiceLine, int, error) {
+	types := make(map[string]string)
+	for _, ch := range characters {
+		types[strings.ToLower(ch.Name)] = ch.Type
+	}
+
+	voices := make(map[string]*voice)
+	var lines []voiceLine
+	for i, exchange := range exchanges {
+		if exchange.Type != beatDialogue || strings.TrimSpace(exchange.Line) == "" {
+			continue
+		}
+
+		key := strings.ToLower(exchange.Character)
+		v, ok := voices[key]
+		if !ok {
+			var status int
+			var err error
+			v, status, err = resolveVoice(0, exchange.Character, types[key], provider, "")
+			if err != nil {
+				return nil, status, err
+			}
+			voices[key] = v
+		}
+		lines = append(lines, voiceLine{index: i, exchange: exchange, voice: v})
+	}
+
+	if len(lines) == 0 {
+		return nil, fiber.StatusBadRequest, errors.New("Dialogue has no lines to voice")
+	}
+	return lines, 0, nil
+}
+
+// renderLines voices lines on a bounded number of workers and joins the clips,
+// in dialogue order, with gapMS of silence between them.
+func renderLines(ctx context.Context, lines []voiceLine, gapMS, concurrency int) (*RenderedDialogue, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	clips := make([][]int16, len(lines))
+	rates := make([]int, len(lines))
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	work := make(chan int)
+	for w := 0; w < min(concurrency, len(lines)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				samples, rate, err := voiceClip(ctx, lines[i])
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("Failed to voice line %d: %v", lines[i].index+1, err)
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				clips[i], rates[i] = samples, rate
+			}
+		}()
+	}
+	for i := range lines {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("Rendering stopped: %v", err)
+	}
+
+	// Mix at the first clip's rate; providers rarely disagree
+	sampleRate := rates[0]
+	gap := make([]int16, gapMS*sampleRate/1000)
+	toMS := func(n int) int { return int(int64(n) * 1000 / int64(sampleRate)) }
+
+	var track []int16
+	manifest := make([]RenderedLine, len(lines))
+	for i, line := range lines {
+		if i > 0 {
+			track = append(track, gap...)
+		}
+		start := len(track)
+		track = append(track, tts.Resample(clips[i], rates[i], sampleRate)...)
+
+		manifest[i] = RenderedLine{
+			Index:     line.index,
+			Character: line.exchange.Character,
+			Line:      line.exchange.Line,
+			StartMS:   toMS(start),
+			EndMS:     toMS(len(track)),
+			Provider:  line.voice.provider.Name(),
+			VoiceID:   line.voice.voiceID,
+		}
+	}
+
+	return &RenderedDialogue{
+		Audio:       tts.EncodeWAV(track, sampleRate),
+		ContentType: "audio/wav",
+		SampleRate:  sampleRate,
+		DurationMS:  toMS(len(track)),
+		Manifest:    manifest,
+	}, nil
+}
+
+// voiceClip synthesizes one line as PCM samples, reusing cached clips.
+func voiceClip(ctx context.Context, line voiceLine) ([]int16, int, error) {
+	audio, _, err := synthesizeCached(ctx, line.voice.provider, tts.Request{
+		Text:     line.exchange.Line,
+		VoiceID:  line.voice.voiceID,
+		Settings: voiceSettingsFor(line.voice.settings, normalizeEmotion(line.exchange.Emotion), line.exchange.Intensity),
+		Format:   tts.FormatWAV,
+	}, true, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tts.DecodeWAV(audio.Data)
+}
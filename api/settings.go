@@ -0,0 +1,159 @@
+// api/settings.go
+package api
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// Sampling defaults for requests that do not set them
+const (
+	defaultTemperature = 0.7
+	defaultMaxTokens   = 1024
+)
+
+// GenerationSettings are optional per-request sampling parameters. Unset
+// fields use the server defaults. DialogueResponse echoes the settings that
+// were actually used, with the defaults filled in.
+type GenerationSettings struct {
+	// Model overrides the provider's configured model; it must be listed in
+	// GENERATION_ALLOWED_MODELS
+	Model             string   `json:"model,omitempty"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	TopP              *float64 `json:"topP,omitempty"`
+	MaxTokens         int      `json:"maxTokens,omitempty"`
+	RepetitionPenalty *float64 `json:"repetitionPenalty,omitempty"`
+	Stop              []string `json:"stop,omitempty"`
+	Seed              *int64   `json:"seed,omitempty"`
+}
+
+// generationLimits bound what GenerationSettings may ask for.
+type generationLimits struct {
+	models         []string // models a request may select; empty disables model selection
+	maxTokens      int
+	maxTemperature float64
+	maxStop        int
+}
+
+// limits are the server's generation limits, loaded by InitGenerator
+var limits = generationLimits{
+	maxTokens:      2048,
+	maxTemperature: 1.5,
+	maxStop:        4,
+}
+
+// loadGenerationLimits reads the generation limits from the environment,
+// keeping the defaults for unset variables.
+func loadGenerationLimits() (generationLimits, error) {
+	l := limits
+
+	for _, model := range strings.Split(os.Getenv("GENERATION_ALLOWED_MODELS"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			l.models = append(l.models, model)
+		}
+	}
+
+	if v := os.Getenv("GENERATION_MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return l, fmt.Errorf("invalid GENERATION_MAX_TOKENS %q", v)
+		}
+		l.maxTokens = n
+	}
+
+	if v := os.Getenv("GENERATION_MAX_TEMPERATURE"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 {
+			return l, fmt.Errorf("invalid GENERATION_MAX_TEMPERATURE %q", v)
+		}
+		l.maxTemperature = t
+	}
+
+	return l, nil
+}
+
+// validate returns a user-facing error message, or "" if the settings are
+// within the limits.
+func (s *GenerationSettings) validate(l generationLimits) string {
+	if s == nil {
+		return ""
+	}
+	if s.Model != "" && !slices.Contains(l.models, s.Model) {
+		if len(l.models) == 0 {
+			return "Model selection is not enabled on this server"
+		}
+		return fmt.Sprintf("Model must be one of: %s", strings.Join(l.models, ", "))
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > l.maxTemperature) {
+		return fmt.Sprintf("Temperature must be between 0 and %g", l.maxTemperature)
+	}
+	if s.TopP != nil && (*s.TopP <= 0 || *s.TopP > 1) {
+		return "Top P must be greater than 0 and at most 1"
+	}
+	if s.MaxTokens < 0 || s.MaxTokens > l.maxTokens {
+		return fmt.Sprintf("Max tokens must be between 1 and %d", l.maxTokens)
+	}
+	if s.RepetitionPenalty != nil && (*s.RepetitionPenalty <= 0 || *s.RepetitionPenalty > 2) {
+		return "Repetition penalty must be greater than 0 and at most 2"
+	}
+	if len(s.Stop) > l.maxStop {
+		return fmt.Sprintf("At most %d stop sequences are allowed", l.maxStop)
+	}
+	for _, stop := range s.Stop {
+		if stop == "" {
+			return "Stop sequences must not be empty"
+		}
+	}
+	return ""
+}
+
+// apply sets the server defaults and any requested settings on a provider
+// request.
+func (s *GenerationSettings) apply(llmReq *llm.Request) {
+	s.applyWithDefaults(llmReq, defaultTemperature, defaultMaxTokens)
+}
+
+// applyWithDefaults is apply with the temperature and max tokens to use when
+// the request does not set them, capped by the server's limits.
+func (s *GenerationSettings) applyWithDefaults(llmReq *llm.Request, temperature float64, maxTokens int) {
+	llmReq.Temperature = min(temperature, limits.maxTemperature)
+	llmReq.MaxTokens = min(maxTokens, limits.maxTokens)
+	if s == nil {
+		return
+	}
+
+	llmReq.Model = s.Model
+	if s.Temperature != nil {
+		llmReq.Temperature = *s.Temperature
+	}
+	if s.MaxTokens > 0 {
+		llmReq.MaxTokens = s.MaxTokens
+	}
+	llmReq.TopP = s.TopP
+	llmReq.RepetitionPenalty = s.RepetitionPenalty
+	llmReq.Stop = s.Stop
+	llmReq.Seed = s.Seed
+}
+
+// usedSettings describes the settings a provider request ran with, naming
+// the model that answered it.
+func usedSettings(llmReq llm.Request, model string) *GenerationSettings {
+	if model == "" {
+		model = llmReq.Model
+	}
+	temperature := llmReq.Temperature
+	return &GenerationSettings{
+		Model:             model,
+		Temperature:       &temperature,
+		TopP:              llmReq.TopP,
+		MaxTokens:         llmReq.MaxTokens,
+		RepetitionPenalty: llmReq.RepetitionPenalty,
+		Stop:              llmReq.Stop,
+		Seed:              llmReq.Seed,
+	}
+}
@@ -0,0 +1,192 @@
+// api/stream.go
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// streamTimeout bounds a single streamed generation
+const streamTimeout = 2 * time.Minute
+
+// GenerateDialogueStream generates a dialogue like GenerateDialogue but sends
+// it as Server-Sent Events: an "exchange" event for every beat as soon as its
+// first line is complete, then a "done" event carrying the full
+// DialogueResponse. When later lines continue a speech that was already sent,
+// an "exchange_update" event carries the speech so far, replacing the last
+// exchange. Failures after the stream has started are sent as an "error" event.
+func GenerateDialogueStream(c *fiber.Ctx) error {
+	var req DialogueRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if msg := validateDialogueRequest(&req); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if req.Format == formatJSON {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "JSON format is not supported for streaming",
+		})
+	}
+	if req.Candidates > 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Multiple candidates are not supported for streaming",
+		})
+	}
+
+	references, err := selectReferences(req)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Failed to generate dialogue: %v", err),
+		})
+	}
+
+	endpoint, apiKey := c.Route().Path, apiKeyFingerprint(c)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// The writer runs after the handler returns, so it must not touch c
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+		defer cancel()
+
+		stream := &exchangeStream{
+			parser: newDialogueParser(req.Characters),
+			send: func(event string, exchange DialogueExchange) error {
+				return writeSSE(w, event, exchange)
+			},
+		}
+
+		llmReq := dialogueLLMRequest(req, references)
+		var pending strings.Builder
+		resp, err := llm.Stream(ctx, generator, llmReq, func(chunk string) error {
+			pending.WriteString(chunk)
+			buffered := pending.String()
+
+			lastNewline := strings.LastIndexByte(buffered, '\n')
+			if lastNewline < 0 {
+				return nil
+			}
+			pending.Reset()
+			pending.WriteString(buffered[lastNewline+1:])
+
+			for _, line := range strings.Split(buffered[:lastNewline], "\n") {
+				// A failed write means the client went away; stop generating
+				if err := stream.feed(line); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			// The final line usually has no trailing newline
+			err = stream.close(pending.String())
+		}
+		if err != nil {
+			writeSSE(w, "error", generationError("generate dialogue", err))
+			return
+		}
+
+		_, meter := withUsageMeter(ctx)
+		meter.add(llmReq, resp)
+		usage := meter.total()
+		recordUsage(endpoint, apiKey, usage)
+
+		writeSSE(w, "done", DialogueResponse{
+			Scenario:     req.Scenario,
+			Exchanges:    stream.exchanges,
+			ReferenceIDs: referenceIDs(references),
+			Rejected:     stream.parser.Rejected(),
+			Settings:     usedSettings(llmReq, resp.Model),
+			Usage:        usage,
+		})
+	})
+
+	return nil
+}
+
+// exchangeStream parses streamed lines and sends each beat once its first
+// line is complete, without waiting for the line that ends it.
+type exchangeStream struct {
+	parser *dialogueParser
+	send   func(event string, exchange DialogueExchange) error
+
+	// sent is the speech in progress as last sent, if it was
+	sent      *DialogueExchange
+	exchanges []DialogueExchange
+}
+
+// feed parses one complete line.
+func (s *exchangeStream) feed(line string) error {
+	return s.emit(s.parser.Feed(line))
+}
+
+// close parses the final, unterminated line and sends what is left.
+func (s *exchangeStream) close(rest string) error {
+	return s.emit(append(s.parser.Feed(rest), s.parser.Close()...))
+}
+
+func (s *exchangeStream) emit(completed []DialogueExchange) error {
+	for i, exchange := range completed {
+		annotateEmotion(&exchange)
+		s.exchanges = append(s.exchanges, exchange)
+
+		// The first completed beat is the speech in progress, if there was one
+		if i == 0 && s.sent != nil {
+			sent := *s.sent
+			s.sent = nil
+			if exchange != sent {
+				if err := s.send("exchange_update", exchange); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := s.send("exchange", exchange); err != nil {
+			return err
+		}
+	}
+
+	current, ok := s.parser.Pending()
+	if !ok {
+		return nil
+	}
+	annotateEmotion(&current)
+	event := "exchange"
+	if s.sent != nil {
+		if current == *s.sent {
+			return nil
+		}
+		event = "exchange_update"
+	}
+	s.sent = &current
+	return s.send(event, current)
+}
+
+// writeSSE writes one Server-Sent Event with a JSON payload and flushes it.
+func writeSSE(w *bufio.Writer, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,80 @@
+package api
+
+import "testing"
+
+type sentEvent struct {
+	event string
+	line  string
+}
+
+func newTestStream(events *[]sentEvent) *exchangeStream {
+	return &exchangeStream{
+		parser: newDialogueParser([]CharacterRequest{{Name: "Detective Smith"}, {Name: "Anna"}}),
+		send: func(event string, exchange DialogueExchange) error {
+			*events = append(*events, sentEvent{event, exchange.Line})
+			return nil
+		},
+	}
+}
+
+func TestExchangeStreamSendsSpeechWhenItsLineEnds(t *testing.T) {
+	var events []sentEvent
+	stream := newTestStream(&events)
+
+	if err := stream.feed("Detective Smith: Where were you last night?"); err != nil {
+		t.Fatal(err)
+	}
+	want := []sentEvent{{"exchange", "Where were you last night?"}}
+	if len(events) != 1 || events[0] != want[0] {
+		t.Fatalf("after the first line sent %v, want %v", events, want)
+	}
+
+	if err := stream.feed("Anna: Home."); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1] != (sentEvent{"exchange", "Home."}) {
+		t.Fatalf("after the second line sent %v, want Anna's line straight away", events)
+	}
+
+	if err := stream.close(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("closing resent unchanged speeches: %v", events)
+	}
+	if len(stream.exchanges) != 2 {
+		t.Errorf("collected %d exchanges, want 2", len(stream.exchanges))
+	}
+}
+
+func TestExchangeStreamUpdatesContinuedSpeech(t *testing.T) {
+	var events []sentEvent
+	stream := newTestStream(&events)
+
+	for _, line := range []string{"ANNA:", "I was home.", "All night."} {
+		if err := stream.feed(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.close("SMITH: Sure you were."); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []sentEvent{
+		{"exchange", "I was home."},
+		{"exchange_update", "I was home. All night."},
+		{"exchange", "Sure you were."},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("sent %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %v, want %v", i, events[i], want[i])
+		}
+	}
+
+	if len(stream.exchanges) != 2 || stream.exchanges[0].Line != "I was home. All night." {
+		t.Errorf("collected %+v, want the continued speech and Smith's reply", stream.exchanges)
+	}
+}
@@ -0,0 +1,210 @@
+// api/subtitles.go
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/subtitles"
+)
+
+// defaultSubtitleWPM is the speaking rate used to estimate timings without audio
+const defaultSubtitleWPM = 150
+
+// Timing sources for saved dialogue exports
+const (
+	timingEstimate = "estimate"
+	timingAudio    = "audio"
+)
+
+// SubtitleOptions control a subtitle export.
+type SubtitleOptions struct {
+	// Format is "srt" (default) or "vtt"
+	Format string `json:"format,omitempty"`
+	// WPM is the speaking rate for estimated timings (default 150)
+	WPM int `json:"wpm,omitempty"`
+	// GapMS is the pause between estimated lines, as in a render (default 500)
+	GapMS *int `json:"gapMs,omitempty"`
+	// MaxLineLength is the most characters per caption row (default 42)
+	MaxLineLength int `json:"maxLineLength,omitempty"`
+}
+
+// SubtitleRequest is a dialogue to caption. A manifest from a render gives
+// the timings; without one they are estimated from the exchanges.
+type SubtitleRequest struct {
+	DialogueResponse
+	Manifest []RenderedLine `json:"manifest,omitempty"`
+	SubtitleOptions
+}
+
+// ExportSubtitles captions an inline dialogue or a rendered one.
+func ExportSubtitles(c *fiber.Ctx) error {
+	var req SubtitleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if len(req.Manifest) > 0 {
+		return sendSubtitles(c, manifestLines(req.Manifest), "dialogue", req.SubtitleOptions)
+	}
+	return sendEstimatedSubtitles(c, req.Exchanges, "dialogue", req.SubtitleOptions)
+}
+
+// ExportSavedSubtitles captions a saved dialogue. The "format", "wpm",
+// "gapMs" and "maxLineLength" query parameters match SubtitleOptions.
+// "timing=audio" voices the dialogue first, with the voices and parameters of
+// a render, and times the captions to the audio.
+func ExportSavedSubtitles(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid dialogue ID",
+		})
+	}
+
+	opts := SubtitleOptions{
+		Format:        c.Query("format"),
+		WPM:           c.QueryInt("wpm"),
+		MaxLineLength: c.QueryInt("maxLineLength"),
+	}
+	if v := c.Query("gapMs"); v != "" {
+		gap, err := strconv.Atoi(v)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Gap must be a number of milliseconds",
+			})
+		}
+		opts.GapMS = &gap
+	}
+
+	saved, status, err := loadSavedScene(id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	name := fmt.Sprintf("dialogue-%d", id)
+
+	switch c.Query("timing", timingEstimate) {
+	case timingEstimate:
+		return sendEstimatedSubtitles(c, saved.Exchanges, name, opts)
+	case timingAudio:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Timing must be \"estimate\" or \"audio\"",
+		})
+	}
+
+	defaultBeatTypes(saved.Exchanges)
+	gapMS, concurrency, msg := renderLimits(RenderOptions{GapMS: opts.GapMS})
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+	lines, status, err := voiceLines(saved.Exchanges, saved.Characters, c.Query("provider"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), renderTimeout)
+	defer cancel()
+
+	rendered, err := renderLines(ctx, lines, gapMS, concurrency)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return sendSubtitles(c, manifestLines(rendered.Manifest), name, opts)
+}
+
+// sendEstimatedSubtitles captions the spoken exchanges, timed at the
+// options' speaking rate.
+func sendEstimatedSubtitles(c *fiber.Ctx, exchanges []DialogueExchange, name string, opts SubtitleOptions) error {
+	if opts.WPM == 0 {
+		opts.WPM = defaultSubtitleWPM
+	}
+	if opts.WPM < 50 || opts.WPM > 400 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Words per minute must be between 50 and 400",
+		})
+	}
+	gapMS, _, msg := renderLimits(RenderOptions{GapMS: opts.GapMS})
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	defaultBeatTypes(exchanges)
+	var lines []subtitles.Line
+	for _, exchange := range exchanges {
+		if exchange.Type != beatDialogue || strings.TrimSpace(exchange.Line) == "" {
+			continue
+		}
+		lines = append(lines, subtitles.Line{Speaker: exchange.Character, Text: exchange.Line})
+	}
+
+	lines = subtitles.Estimate(lines, opts.WPM, time.Duration(gapMS)*time.Millisecond)
+	return sendSubtitles(c, lines, name, opts)
+}
+
+// manifestLines takes caption timings from a render.
+func manifestLines(manifest []RenderedLine) []subtitles.Line {
+	lines := make([]subtitles.Line, len(manifest))
+	for i, m := range manifest {
+		lines[i] = subtitles.Line{
+			Speaker: m.Character,
+			Text:    m.Line,
+			Start:   time.Duration(m.StartMS) * time.Millisecond,
+			End:     time.Duration(m.EndMS) * time.Millisecond,
+		}
+	}
+	return lines
+}
+
+// sendSubtitles writes timed lines as a subtitle file named name.
+func sendSubtitles(c *fiber.Ctx, lines []subtitles.Line, name string, opts SubtitleOptions) error {
+	if len(lines) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Dialogue has no lines to caption",
+		})
+	}
+	if opts.MaxLineLength != 0 && (opts.MaxLineLength < 10 || opts.MaxLineLength > 200) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Max line length must be between 10 and 200",
+		})
+	}
+
+	cues := subtitles.Cues(lines, subtitles.Options{MaxLineLength: opts.MaxLineLength})
+
+	var buf bytes.Buffer
+	format := strings.ToLower(opts.Format)
+	switch format {
+	case "", subtitles.FormatSRT:
+		format = subtitles.FormatSRT
+		c.Set("Content-Type", "application/x-subrip; charset=utf-8")
+		subtitles.WriteSRT(&buf, cues)
+	case subtitles.FormatVTT:
+		c.Set("Content-Type", "text/vtt; charset=utf-8")
+		subtitles.WriteVTT(&buf, cues)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Format must be \"srt\" or \"vtt\"",
+		})
+	}
+
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	return c.Send(buf.Bytes())
+}
@@ -0,0 +1,227 @@
+// api/usage.go
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/llm"
+)
+
+// Usage is the token usage and cost of a generation.
+type Usage struct {
+	Calls            int    `json:"calls"`
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int    `json:"promptTokens"`
+	CompletionTokens int    `json:"completionTokens"`
+	TotalTokens      int    `json:"totalTokens"`
+	// Estimated is set when any count is a local estimate because the
+	// backend did not report it
+	Estimated bool `json:"estimated"`
+	// Cost is in US dollars, when the model has a price in MODEL_PRICES
+	Cost *float64 `json:"cost,omitempty"`
+}
+
+// ModelPrice is what a model costs in US dollars per million tokens.
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// modelPrices maps model IDs to prices; "*" prices every other model.
+// Loaded by InitGenerator.
+var modelPrices map[string]ModelPrice
+
+// loadModelPrices reads MODEL_PRICES, a JSON object mapping model IDs to
+// prices, e.g. {"gpt-4o-mini": {"prompt": 0.15, "completion": 0.6}}.
+func loadModelPrices() (map[string]ModelPrice, error) {
+	v := os.Getenv("MODEL_PRICES")
+	if v == "" {
+		return nil, nil
+	}
+	var prices map[string]ModelPrice
+	if err := json.Unmarshal([]byte(v), &prices); err != nil {
+		return nil, fmt.Errorf("invalid MODEL_PRICES: %w", err)
+	}
+	return prices, nil
+}
+
+// usageMeter adds up the provider calls made for one generation.
+type usageMeter struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+type usageMeterKey struct{}
+
+// withUsageMeter returns a context under which callProvider counts usage
+// into the returned meter.
+func withUsageMeter(ctx context.Context) (context.Context, *usageMeter) {
+	meter := &usageMeter{}
+	return context.WithValue(ctx, usageMeterKey{}, meter), meter
+}
+
+// callProvider generates with the configured provider, counting the call's
+// usage into the context's meter.
+func callProvider(ctx context.Context, llmReq llm.Request) (*llm.Response, error) {
+	resp, err := generator.Generate(ctx, llmReq)
+	if err != nil {
+		return nil, err
+	}
+	if meter, ok := ctx.Value(usageMeterKey{}).(*usageMeter); ok {
+		meter.add(llmReq, resp)
+	}
+	return resp, nil
+}
+
+// add counts one call, estimating the counts the backend did not report.
+func (m *usageMeter) add(llmReq llm.Request, resp *llm.Response) {
+	var reported llm.Usage
+	if resp.Usage != nil {
+		reported = *resp.Usage
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage.Calls++
+	if m.usage.Model == "" {
+		m.usage.Model = resp.Model
+	}
+	if reported.PromptTokens > 0 {
+		m.usage.PromptTokens += reported.PromptTokens
+	} else {
+		m.usage.PromptTokens += llm.EstimateTokens(llmReq.SystemPrompt) + llm.EstimateTokens(llmReq.Prompt)
+		m.usage.Estimated = true
+	}
+	if reported.CompletionTokens > 0 {
+		m.usage.CompletionTokens += reported.CompletionTokens
+	} else {
+		m.usage.CompletionTokens += llm.EstimateTokens(resp.Text)
+		m.usage.Estimated = true
+	}
+}
+
+// total is the usage so far, priced, or nil if no call was made.
+func (m *usageMeter) total() *Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.usage.Calls == 0 {
+		return nil
+	}
+	usage := m.usage
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	price, ok := modelPrices[usage.Model]
+	if !ok {
+		price, ok = modelPrices["*"]
+	}
+	if ok {
+		cost := (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+		cost = math.Round(cost*1e6) / 1e6
+		usage.Cost = &cost
+	}
+	return &usage
+}
+
+// apiKeyFingerprint identifies the caller's X-API-Key without storing it.
+func apiKeyFingerprint(c *fiber.Ctx) string {
+	key := c.Get("X-API-Key")
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// recordUsage stores a generation's usage. Failures are logged, since the
+// generation itself succeeded.
+func recordUsage(endpoint, apiKey string, usage *Usage) {
+	if usage == nil {
+		return
+	}
+	err := db.RecordUsage(db.UsageRecord{
+		Endpoint:         endpoint,
+		Provider:         generator.Name(),
+		Model:            usage.Model,
+		APIKey:           apiKey,
+		Calls:            usage.Calls,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Estimated:        usage.Estimated,
+		Cost:             usage.Cost,
+	})
+	if err != nil {
+		log.Printf("Failed to record usage: %v", err)
+	}
+}
+
+// GetUsage totals recorded usage between the "from" and "to" days
+// (YYYY-MM-DD, default the last 30 days), broken down by the comma-separated
+// "groupBy" fields: "model", "day" and "key" (default all three).
+func GetUsage(c *fiber.Ctx) error {
+	from := c.Query("from", time.Now().AddDate(0, 0, -29).Format(time.DateOnly))
+	to := c.Query("to", time.Now().Format(time.DateOnly))
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Dates must be formatted as YYYY-MM-DD",
+			})
+		}
+	}
+
+	var groupBy []string
+	for _, g := range strings.Split(c.Query("groupBy", "model,day,key"), ",") {
+		g = strings.TrimSpace(g)
+		if g == "" || slices.Contains(groupBy, g) {
+			continue
+		}
+		if g != "model" && g != "day" && g != "key" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Group by must list \"model\", \"day\" or \"key\"",
+			})
+		}
+		groupBy = append(groupBy, g)
+	}
+
+	groups, err := db.GetUsageTotals(from, to, groupBy)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch usage",
+		})
+	}
+	if groups == nil {
+		groups = []db.UsageTotal{}
+	}
+
+	var totals db.UsageTotal
+	for _, g := range groups {
+		totals.Requests += g.Requests
+		totals.Calls += g.Calls
+		totals.PromptTokens += g.PromptTokens
+		totals.CompletionTokens += g.CompletionTokens
+		totals.Cost += g.Cost
+	}
+	totals.Cost = math.Round(totals.Cost*1e6) / 1e6
+
+	return c.JSON(fiber.Map{
+		"from":    from,
+		"to":      to,
+		"groupBy": groupBy,
+		"totals":  totals,
+		"groups":  groups,
+	})
+}
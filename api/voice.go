@@ -1,130 +1,410 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/db"
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/tts"
 )
 
 type VoiceRequest struct {
-	Character string `json:"character"`
-	Text      string `json:"text"`
-	VoiceID   string `json:"voiceId"` // Optional: specific voice ID to use
+	// The speaker, by ID or by name. A name that matches no saved character
+	// is treated as a character type such as "hero".
+	CharacterID int    `json:"characterId"`
+	Character   string `json:"character"`
+	Text        string `json:"text"`
+	VoiceID     string `json:"voiceId"` // Optional: specific voice ID to use
+	// Optional: speech provider to use instead of the character's or the default
+	Provider string `json:"provider"`
+
+	// Optional: emotion annotations from a generated exchange
+	Emotion   string  `json:"emotion"`
+	Intensity float64 `json:"intensity"`
+}
+
+// voiceProviders holds every speech provider by name, configured by InitVoices
+var voiceProviders map[string]tts.Provider
+
+// defaultVoiceProvider names the provider used when neither the request nor
+// the character picks one
+var defaultVoiceProvider string
+
+// characterVoiceProviders maps lower-cased character names to provider names
+var characterVoiceProviders map[string]string
+
+// InitVoices configures the speech providers. TTS_PROVIDER picks the default
+// and TTS_CHARACTER_PROVIDERS assigns providers to characters, as
+// comma-separated name=provider pairs such as "Narrator=tone,hero=elevenlabs".
+func InitVoices() {
+	providers, name, err := tts.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure speech provider:", err)
+	}
+
+	characters := make(map[string]string)
+	if v := os.Getenv("TTS_CHARACTER_PROVIDERS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			character, provider, ok := strings.Cut(pair, "=")
+			character = strings.ToLower(strings.TrimSpace(character))
+			provider = strings.ToLower(strings.TrimSpace(provider))
+			if _, known := providers[provider]; !ok || character == "" || !known {
+				log.Fatalf("Invalid TTS_CHARACTER_PROVIDERS entry %q", pair)
+			}
+			characters[character] = provider
+		}
+	}
+
+	clips, err := loadAudioCache()
+	if err != nil {
+		log.Fatal("Failed to configure audio cache:", err)
+	}
+
+	voiceProviders, defaultVoiceProvider, characterVoiceProviders = providers, name, characters
+	clipCache = clips
+	log.Printf("Using speech provider %s", name)
 }
 
-type ElevenLabsRequest struct {
-	Text      string    `json:"text"`
-	ModelID   string    `json:"model_id"`
-	VoiceSettings VoiceSettings `json:"voice_settings"`
+// voiceProviderFor picks the provider by name, else the character's
+// configured provider, else the default.
+func voiceProviderFor(name, character string) (tts.Provider, error) {
+	if name == "" {
+		name = characterVoiceProviders[strings.ToLower(character)]
+	}
+	if name == "" {
+		name = defaultVoiceProvider
+	}
+
+	provider, ok := voiceProviders[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(voiceProviders))
+		for n := range voiceProviders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown voice provider %q; available: %s", name, strings.Join(names, ", "))
+	}
+	return provider, nil
 }
 
-type VoiceSettings struct {
-	Stability       float64 `json:"stability"`
-	SimilarityBoost float64 `json:"similarity_boost"`
+// voice is a resolved speaker: who they are, which provider speaks for them
+// and with what voice and settings.
+type voice struct {
+	character string
+	provider  tts.Provider
+	voiceID   string
+	settings  db.VoiceProfile
 }
 
-func SynthesizeVoice(c *fiber.Ctx) error {
-	var req VoiceRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+// resolveVoice works out how a character sounds, returning an HTTP status to
+// use on error. The request's provider and voice ID win; otherwise the
+// character's own voice profile, then the voice defaults for its type, then
+// the "default" type fill in what is missing. A profile's voice ID is only
+// used with the provider it was chosen for. characterType is the type to use
+// when no saved character matches; if empty, the name is taken as the type.
+func resolveVoice(characterID int, name, characterType, providerName, voiceID string) (*voice, int, error) {
+	var character *db.Character
+	var err error
+	if characterID != 0 {
+		character, err = db.GetCharacter(characterID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fiber.StatusNotFound, errors.New("Character not found")
+		}
+	} else if name != "" {
+		character, err = db.GetCharacterByName(name)
+		if errors.Is(err, sql.ErrNoRows) {
+			character, err = nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, errors.New("Failed to fetch character")
 	}
 
-	if req.Text == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Text is required",
-		})
+	// Without a saved character the name may double as the type, as in "hero"
+	if characterType == "" {
+		characterType = name
+	}
+	var profiles []db.VoiceProfile
+	if character != nil {
+		name, characterType = character.Name, character.Type
+		if character.Voice != nil {
+			profiles = append(profiles, *character.Voice)
+		}
+	}
+	types := []string{db.DefaultVoiceType}
+	if characterType != "" && !strings.EqualFold(characterType, db.DefaultVoiceType) {
+		types = []string{characterType, db.DefaultVoiceType}
+	}
+	for _, t := range types {
+		profile, err := db.GetVoiceDefault(t)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fiber.StatusInternalServerError, errors.New("Failed to fetch voice defaults")
+		}
+		profiles = append(profiles, *profile)
 	}
 
-	// Get voice ID (either from request or based on character)
-	voiceID := req.VoiceID
-	if voiceID == "" {
-		// Map character to a default voice ID
-		// This would be expanded with more sophisticated mapping in production
-		voiceMap := map[string]string{
-			"hero":      "21m00Tcm4TlvDq8ikWAM", // Example voice IDs
-			"villain":   "AZnzlk1XvdvUeBnXmlld",
-			"sidekick":  "EXAVITQu4vr4xnSDxMaL",
-			"detective": "MF3mGyEYCl7XYWbV9V6O",
-			"default":   "EXAVITQu4vr4xnSDxMaL",
+	if providerName == "" && character != nil && character.Voice != nil {
+		providerName = character.Voice.Provider
+	}
+	provider, err := voiceProviderFor(providerName, name)
+	if err != nil {
+		return nil, fiber.StatusBadRequest, err
+	}
+
+	v := &voice{character: name, provider: provider, voiceID: voiceID}
+	for _, profile := range profiles {
+		if v.voiceID == "" && (profile.Provider == "" || strings.EqualFold(profile.Provider, provider.Name())) {
+			v.voiceID = profile.VoiceID
 		}
+		v.settings = mergeVoiceSettings(v.settings, profile)
+	}
+	if v.voiceID == "" {
+		v.voiceID = provider.DefaultVoice(name)
+	}
+	if v.voiceID == "" {
+		who := name
+		if who == "" {
+			who = "the speaker"
+		}
+		return nil, fiber.StatusUnprocessableEntity, fmt.Errorf(
+			"No %s voice for %s: assign the character a voice profile, add voice defaults for type %q or %q, or pass a voiceId",
+			provider.Name(), who, characterType, db.DefaultVoiceType)
+	}
+	return v, 0, nil
+}
+
+// mergeVoiceSettings fills settings missing from a with those from b.
+func mergeVoiceSettings(a, b db.VoiceProfile) db.VoiceProfile {
+	if a.Stability == nil {
+		a.Stability = b.Stability
+	}
+	if a.SimilarityBoost == nil {
+		a.SimilarityBoost = b.SimilarityBoost
+	}
+	if a.SpeakingRate == nil {
+		a.SpeakingRate = b.SpeakingRate
+	}
+	if a.Pitch == nil {
+		a.Pitch = b.Pitch
+	}
+	return a
+}
+
+// voiceSettingsFor turns a voice profile into settings for a line's emotion:
+// stronger emotions get a less stable, more expressive delivery. Stability
+// and similarity default to 0.75.
+func voiceSettingsFor(profile db.VoiceProfile, emotion string, intensity float64) tts.VoiceSettings {
+	settings := tts.VoiceSettings{
+		Stability:       0.75,
+		SimilarityBoost: 0.75,
+	}
+	if profile.Stability != nil {
+		settings.Stability = *profile.Stability
+	}
+	if profile.SimilarityBoost != nil {
+		settings.SimilarityBoost = *profile.SimilarityBoost
+	}
+	if profile.SpeakingRate != nil {
+		settings.Speed = *profile.SpeakingRate
+	}
+	if profile.Pitch != nil {
+		settings.Pitch = *profile.Pitch
+	}
+	if emotion == "" || emotion == emotionNeutral {
+		return settings
+	}
+
+	intensity = clampUnit(intensity)
+	settings.Stability = clampUnit(settings.Stability * (1 - 0.6*intensity))
+	settings.Style = intensity
+	return settings
+}
 
-		_, ok := voiceMap[req.Character]
-		if !ok {
-			voiceID = voiceMap["default"]
+// validateVoiceProfile checks a profile before it is stored.
+func validateVoiceProfile(profile *db.VoiceProfile) string {
+	if profile == nil {
+		return ""
+	}
+	profile.Provider = strings.ToLower(strings.TrimSpace(profile.Provider))
+	profile.VoiceID = strings.TrimSpace(profile.VoiceID)
+	if _, ok := voiceProviders[profile.Provider]; profile.Provider != "" && !ok {
+		return fmt.Sprintf("Unknown voice provider %q", profile.Provider)
+	}
+	for name, v := range map[string]*float64{"Stability": profile.Stability, "Similarity boost": profile.SimilarityBoost} {
+		if v != nil && (*v < 0 || *v > 1) {
+			return name + " must be between 0 and 1"
 		}
 	}
+	if v := profile.SpeakingRate; v != nil && (*v < 0.5 || *v > 2) {
+		return "Speaking rate must be between 0.5 and 2"
+	}
+	if v := profile.Pitch; v != nil && (*v < -12 || *v > 12) {
+		return "Pitch must be between -12 and 12 semitones"
+	}
+	return ""
+}
 
-	// Prepare request to ElevenLabs API
-	apiKey := os.Getenv("ELEVENLABS_API_KEY")
-	if apiKey == "" {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Voice synthesis API key not configured",
+// SetCharacterVoice replaces a saved character's voice profile. A null body
+// clears it.
+func SetCharacterVoice(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid character ID",
 		})
 	}
 
-	elevenLabsReq := ElevenLabsRequest{
-		Text:    req.Text,
-		ModelID: "eleven_monolingual_v1",
-		VoiceSettings: VoiceSettings{
-			Stability:       0.75,
-			SimilarityBoost: 0.75,
-		},
+	var profile *db.VoiceProfile
+	if err := c.BodyParser(&profile); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if msg := validateVoiceProfile(profile); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
 	}
 
-	jsonData, err := json.Marshal(elevenLabsReq)
+	err = db.SetCharacterVoice(id, profile)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Character not found",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to serialize request",
+			"error": "Failed to update character voice",
 		})
 	}
 
-	// Make request to ElevenLabs API
-	url := fmt.Sprintf("https://api.elevenlabs.io/v1/text-to-speech/%s", voiceID)
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	character, err := db.GetCharacter(id)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create HTTP request",
+			"error": "Failed to fetch character",
 		})
 	}
+	return c.JSON(character)
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("xi-api-key", apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+// GetVoiceDefaults lists the voice defaults by character type.
+func GetVoiceDefaults(c *fiber.Ctx) error {
+	defaults, err := db.GetVoiceDefaults()
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to make voice synthesis request",
+			"error": "Failed to fetch voice defaults",
+		})
+	}
+
+	return c.JSON(defaults)
+}
+
+// SetVoiceDefault stores the voice defaults for a character type; the
+// "default" type covers types without their own.
+func SetVoiceDefault(c *fiber.Ctx) error {
+	characterType := strings.TrimSpace(c.Params("type"))
+	if characterType == "" || len(characterType) > 50 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Character type must be between 1 and 50 characters",
+		})
+	}
+
+	var profile db.VoiceProfile
+	if err := c.BodyParser(&profile); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if msg := validateVoiceProfile(&profile); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
 		})
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
+	if err := db.SetVoiceDefault(characterType, profile); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":    "Voice synthesis API error",
-			"response": string(bodyBytes),
+			"error": "Failed to save voice defaults",
 		})
 	}
 
-	// Set appropriate headers for audio file
-	c.Set("Content-Type", "audio/mpeg")
-	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s_dialogue.mp3\"", req.Character))
+	return c.JSON(fiber.Map{
+		"type":    strings.ToLower(characterType),
+		"profile": profile,
+	})
+}
+
+func SynthesizeVoice(c *fiber.Ctx) error {
+	var req VoiceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.Text == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Text is required",
+		})
+	}
 
-	// Stream the audio data directly to the client
-	audio, err := io.ReadAll(resp.Body)
+	v, status, err := resolveVoice(req.CharacterID, req.Character, "", req.Provider, req.VoiceID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read audio response",
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	ttsReq := tts.Request{
+		Text:     req.Text,
+		VoiceID:  v.voiceID,
+		Settings: voiceSettingsFor(v.settings, normalizeEmotion(req.Emotion), req.Intensity),
+	}
+
+	// The clip is addressed by what was asked for, so a client holding it
+	// can revalidate without anything being synthesized. The tag is weak
+	// because a provider may not reproduce the same bytes.
+	etag := fmt.Sprintf("W/%q", audioCacheKey(v.provider.Name(), ttsReq))
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), etag) {
+		c.Set(fiber.HeaderETag, etag)
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	read, write := cachePolicy(c)
+	audio, cacheStatus, err := synthesizeCached(c.UserContext(), v.provider, ttsReq, read, write)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("Voice synthesis failed: %v", err),
 		})
 	}
 
-	return c.Send(audio)
-}
\ No newline at end of file
+	// Set appropriate headers for audio file
+	c.Set("Content-Type", audio.ContentType)
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s_dialogue.%s\"", v.character, audio.Extension))
+	c.Set(fiber.HeaderETag, etag)
+	c.Set("X-Voice-Provider", v.provider.Name())
+	c.Set("X-Voice-ID", v.voiceID)
+	c.Set("X-Cache", strings.ToUpper(cacheStatus))
+
+	return c.Send(audio.Data)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly. "*" does not match: the client must have seen this clip before.
+func etagMatches(ifNoneMatch, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
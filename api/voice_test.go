@@ -0,0 +1,24 @@
+package api
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	const etag = `W/"abc123"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{``, false},
+		{`*`, false},
+		{`"other"`, false},
+		{`"abc123"`, true},
+		{`W/"abc123"`, true},
+		{`"other", W/"abc123"`, true},
+		{`"abc1234"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
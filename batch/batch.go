@@ -0,0 +1,218 @@
+// batch/batch.go
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Record statuses
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Request is one line of a JSONL input file.
+type Request struct {
+	Line int // 1-based line number in the input
+	Body json.RawMessage
+}
+
+// Record is one line of a JSONL output file, describing how an input line went.
+type Record struct {
+	Line       int             `json:"line"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Code       string          `json:"code,omitempty"`
+	StartedAt  time.Time       `json:"startedAt"`
+	DurationMS int64           `json:"durationMs"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+// MaxRate is the highest rate a batch may be limited to, in requests per second
+const MaxRate = 1000
+
+// ValidRate reports whether rate is a usable Options.Rate: 0 for no limit, or
+// a finite rate up to MaxRate.
+func ValidRate(rate float64) bool {
+	return !math.IsNaN(rate) && rate >= 0 && rate <= MaxRate
+}
+
+// Options control how a batch runs.
+type Options struct {
+	// Concurrency is how many requests run at once (default 1)
+	Concurrency int
+	// Rate caps how many requests start per second; 0 means no cap
+	Rate float64
+	// Skip holds input lines that already completed, see Completed
+	Skip map[int]bool
+}
+
+// Func handles one request body. Errors can carry a machine-readable code by
+// being or wrapping an *Error.
+type Func func(ctx context.Context, body json.RawMessage) (json.RawMessage, error)
+
+// Error is a failed request with a machine-readable code.
+type Error struct {
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CodeInvalidJSON marks input lines that are not JSON objects
+const CodeInvalidJSON = "invalid_json"
+
+// ReadRequests reads a JSONL input, skipping blank lines. Lines that are not
+// valid JSON are kept so their failure shows up in the output.
+func ReadRequests(r io.Reader) ([]Request, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var requests []Request
+	for line := 1; scanner.Scan(); line++ {
+		body := bytes.TrimSpace(scanner.Bytes())
+		if len(body) == 0 {
+			continue
+		}
+		requests = append(requests, Request{Line: line, Body: append(json.RawMessage(nil), body...)})
+	}
+	return requests, scanner.Err()
+}
+
+// Completed reads a previous, possibly partial, output and returns the input
+// lines that succeeded. A truncated last record is ignored, so its line runs
+// again.
+func Completed(r io.Reader) (map[int]bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	done := make(map[int]bool)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.Status == StatusOK {
+			done[record.Line] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// Run calls fn for every request not in opts.Skip and passes a record for
+// each to emit, in the order they finish. emit is never called concurrently;
+// if it returns an error the batch stops and Run returns that error.
+func Run(ctx context.Context, requests []Request, opts Options, fn Func, emit func(Record) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan Request)
+	go func() {
+		defer close(work)
+
+		// Rates too high to space out at all run unlimited
+		var tick <-chan time.Time
+		if interval := rateInterval(opts.Rate); interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		first := true
+		for _, req := range requests {
+			if opts.Skip[req.Line] {
+				continue
+			}
+			if tick != nil && !first {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			first = false
+
+			select {
+			case work <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mu      sync.Mutex
+		emitErr error
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < max(opts.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range work {
+				record := runOne(ctx, req, fn)
+
+				mu.Lock()
+				if emitErr == nil {
+					if emitErr = emit(record); emitErr != nil {
+						cancel()
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if emitErr != nil {
+		return emitErr
+	}
+	return ctx.Err()
+}
+
+// rateInterval is the time between request starts at rate, or 0 for no limit.
+func rateInterval(rate float64) time.Duration {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+func runOne(ctx context.Context, req Request, fn Func) Record {
+	record := Record{Line: req.Line, Status: StatusOK, StartedAt: time.Now().UTC()}
+
+	var result json.RawMessage
+	var err error
+	if json.Valid(req.Body) {
+		result, err = fn(ctx, req.Body)
+	} else {
+		err = &Error{Code: CodeInvalidJSON, Err: errors.New("line is not valid JSON")}
+	}
+
+	record.DurationMS = time.Since(record.StartedAt).Milliseconds()
+	if err != nil {
+		record.Status = StatusError
+		record.Error = err.Error()
+		var batchErr *Error
+		if errors.As(err, &batchErr) {
+			record.Code = batchErr.Code
+		}
+		return record
+	}
+	record.Result = result
+	return record
+}
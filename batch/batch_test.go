@@ -0,0 +1,64 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRunUnlimitedRates(t *testing.T) {
+	var requests []Request
+	for i := 1; i <= 3; i++ {
+		requests = append(requests, Request{Line: i, Body: json.RawMessage(`{}`)})
+	}
+	echo := func(ctx context.Context, body json.RawMessage) (json.RawMessage, error) {
+		return body, nil
+	}
+
+	// Rates too high for a ticker interval must not panic
+	for _, rate := range []float64{math.Inf(1), 2e9, math.NaN(), 0} {
+		t.Run(fmt.Sprint(rate), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			done := 0
+			err := Run(ctx, requests, Options{Rate: rate}, echo, func(record Record) error {
+				if record.Status != StatusOK {
+					t.Errorf("line %d: %s", record.Line, record.Error)
+				}
+				done++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != len(requests) {
+				t.Errorf("ran %d requests, want %d", done, len(requests))
+			}
+		})
+	}
+}
+
+func TestValidRate(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want bool
+	}{
+		{0, true},
+		{0.5, true},
+		{MaxRate, true},
+		{MaxRate + 1, false},
+		{2e9, false},
+		{-1, false},
+		{math.Inf(1), false},
+		{math.NaN(), false},
+	}
+	for _, tt := range tests {
+		if got := ValidRate(tt.rate); got != tt.want {
+			t.Errorf("ValidRate(%v) = %v, want %v", tt.rate, got, tt.want)
+		}
+	}
+}
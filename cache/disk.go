@@ -0,0 +1,201 @@
+// cache/disk.go
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Disk is a concurrency-safe store of files in a directory, capped at a total
+// size. When full it evicts the least recently used files. Each file is named
+// by its key and an extension, and access times are kept in the files'
+// modification times so the order survives restarts.
+type Disk struct {
+	dir      string
+	maxBytes int64
+	onEvict  func(key string)
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // most recently used at the front
+	items map[string]*list.Element
+}
+
+type diskEntry struct {
+	key  string
+	ext  string
+	size int64
+}
+
+// OpenDisk opens the store in dir, creating the directory if needed and
+// indexing the files already there. onEvict, when not nil, is called with the
+// key of each evicted file, including any evicted while opening.
+func OpenDisk(dir string, maxBytes int64, onEvict func(key string)) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type found struct {
+		entry diskEntry
+		used  time.Time
+	}
+	var existing []found
+	for _, f := range files {
+		if !f.Type().IsRegular() {
+			continue
+		}
+		if strings.HasPrefix(f.Name(), ".") {
+			// Left over from an interrupted write
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		ext := filepath.Ext(f.Name())
+		existing = append(existing, found{
+			entry: diskEntry{key: strings.TrimSuffix(f.Name(), ext), ext: strings.TrimPrefix(ext, "."), size: info.Size()},
+			used:  info.ModTime(),
+		})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].used.After(existing[j].used) })
+
+	d := &Disk{dir: dir, maxBytes: maxBytes, onEvict: onEvict, order: list.New(), items: make(map[string]*list.Element)}
+	for _, f := range existing {
+		e := f.entry
+		d.items[e.key] = d.order.PushBack(&e)
+		d.size += e.size
+	}
+	d.evicted(d.evict())
+	return d, nil
+}
+
+// Get returns the file stored under key and its extension.
+func (d *Disk) Get(key string) ([]byte, string, bool) {
+	d.mu.Lock()
+	el, ok := d.items[key]
+	if !ok {
+		d.mu.Unlock()
+		return nil, "", false
+	}
+	e := *el.Value.(*diskEntry)
+	d.order.MoveToFront(el)
+	d.mu.Unlock()
+
+	path := d.path(e.key, e.ext)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Removed behind our back
+		d.mu.Lock()
+		if el, ok := d.items[key]; ok {
+			d.remove(el)
+		}
+		d.mu.Unlock()
+		return nil, "", false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, e.ext, true
+}
+
+// Put stores data under key with the given extension, replacing any earlier
+// file. Files larger than the whole store are not kept.
+func (d *Disk) Put(key, ext string, data []byte) error {
+	if strings.ContainsAny(key+ext, `/\.`) || key == "" || strings.HasPrefix(key, ".") {
+		return errors.New("invalid cache key or extension")
+	}
+	if int64(len(data)) > d.maxBytes {
+		return nil
+	}
+
+	// Write to a temporary file first so readers never see a partial file
+	tmp, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key, ext))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	if el, ok := d.items[key]; ok {
+		e := el.Value.(*diskEntry)
+		if e.ext != ext {
+			os.Remove(d.path(e.key, e.ext))
+		}
+		d.order.Remove(el)
+		delete(d.items, key)
+		d.size -= e.size
+	}
+	d.items[key] = d.order.PushFront(&diskEntry{key: key, ext: ext, size: int64(len(data))})
+	d.size += int64(len(data))
+	evicted := d.evict()
+	d.mu.Unlock()
+
+	d.evicted(evicted)
+	return nil
+}
+
+// Size is the total size of the stored files in bytes.
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// evict removes least recently used files until the store fits, returning
+// their keys. The caller holds d.mu, except while opening.
+func (d *Disk) evict() []string {
+	var evicted []string
+	for d.size > d.maxBytes && d.order.Len() > 0 {
+		el := d.order.Back()
+		e := el.Value.(*diskEntry)
+		os.Remove(d.path(e.key, e.ext))
+		d.remove(el)
+		evicted = append(evicted, e.key)
+	}
+	return evicted
+}
+
+// evicted reports evicted keys to onEvict. The caller does not hold d.mu.
+func (d *Disk) evicted(keys []string) {
+	if d.onEvict == nil {
+		return
+	}
+	for _, key := range keys {
+		d.onEvict(key)
+	}
+}
+
+func (d *Disk) remove(el *list.Element) {
+	e := el.Value.(*diskEntry)
+	d.order.Remove(el)
+	delete(d.items, e.key)
+	d.size -= e.size
+}
+
+func (d *Disk) path(key, ext string) string {
+	if ext == "" {
+		return filepath.Join(d.dir, key)
+	}
+	return filepath.Join(d.dir, key+"."+ext)
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOpenDiskReportsEvictions(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for i, key := range []string{"oldest", "newer"} {
+		path := filepath.Join(dir, key+".wav")
+		if err := os.WriteFile(path, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := old.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, used, used)
+	}
+
+	var evicted []string
+	d, err := OpenDisk(dir, 15, func(key string) { evicted = append(evicted, key) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(evicted, []string{"oldest"}) {
+		t.Errorf("evicted %v while opening, want [oldest]", evicted)
+	}
+	if _, _, ok := d.Get("newer"); !ok {
+		t.Error("the most recently used file was evicted")
+	}
+}
@@ -0,0 +1,85 @@
+// cache/lru.go
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a fixed-size, concurrency-safe cache of byte values that expire at a
+// given time. When full it evicts the least recently used entry.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // most recently used at the front
+	items    map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU returns an empty cache holding at most capacity entries.
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored under key and when it expires. Expired
+// entries are removed and reported as missing.
+func (c *LRU) Get(key string) ([]byte, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	e := el.Value.(*entry)
+	if !time.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, time.Time{}, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, e.expires, true
+}
+
+// Put stores value under key until expires, replacing any earlier value.
+func (c *LRU) Put(key string, value []byte, expires time.Time) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// Len is the number of entries, including expired ones not yet removed.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
@@ -0,0 +1,184 @@
+// cmd/cli/batch.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nguyenhoanganh1808/movie-dialogue-generator/batch"
+)
+
+// runBatch implements the "batch" subcommand: it generates a dialogue for
+// every line of a JSONL file of requests and appends one JSONL record per
+// line to the output file. Lines that already succeeded in the output file
+// are skipped, so an interrupted run can be resumed with the same command.
+func runBatch(args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	inputPath := fs.String("input", "", "JSONL file with one dialogue request per line")
+	outputPath := fs.String("output", "", "JSONL file to append results to (default: input with .out.jsonl)")
+	concurrency := fs.Int("concurrency", 2, "Number of requests to run at once")
+	rate := fs.Float64("rate", 0, "Maximum requests started per second (0 for no limit)")
+	apiURL := fs.String("api", "http://localhost:8080", "API base URL")
+	fs.Parse(args)
+
+	if *inputPath == "" {
+		fmt.Println("Error: -input is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !batch.ValidRate(*rate) {
+		fmt.Printf("Error: -rate must be between 0 and %d\n", batch.MaxRate)
+		os.Exit(1)
+	}
+	if *outputPath == "" {
+		*outputPath = *inputPath + ".out.jsonl"
+	}
+
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		fmt.Println("Error opening input:", err)
+		os.Exit(1)
+	}
+	requests, err := batch.ReadRequests(input)
+	input.Close()
+	if err != nil {
+		fmt.Println("Error reading input:", err)
+		os.Exit(1)
+	}
+
+	skip, err := completedLines(*outputPath)
+	if err != nil {
+		fmt.Println("Error reading previous output:", err)
+		os.Exit(1)
+	}
+
+	output, err := openForAppend(*outputPath)
+	if err != nil {
+		fmt.Println("Error opening output:", err)
+		os.Exit(1)
+	}
+	defer output.Close()
+
+	fmt.Printf("Running %d requests (%d already done), writing to %s\n", len(requests)-len(skip), len(skip), *outputPath)
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	generate := func(ctx context.Context, body json.RawMessage) (json.RawMessage, error) {
+		return postGenerate(ctx, client, *apiURL, body)
+	}
+
+	failed := 0
+	opts := batch.Options{Concurrency: *concurrency, Rate: *rate, Skip: skip}
+	err = batch.Run(context.Background(), requests, opts, generate, func(record batch.Record) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := output.Write(append(data, '\n')); err != nil {
+			return err
+		}
+
+		if record.Status == batch.StatusOK {
+			fmt.Printf("line %d: ok (%dms)\n", record.Line, record.DurationMS)
+		} else {
+			failed++
+			fmt.Printf("line %d: %s: %s\n", record.Line, record.Code, record.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		fmt.Printf("%d requests failed; run the same command again to retry them\n", failed)
+		os.Exit(1)
+	}
+}
+
+// postGenerate sends one request to the generate endpoint.
+func postGenerate(ctx context.Context, client *http.Client, apiURL string, body json.RawMessage) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &batch.Error{Code: "request_failed", Err: err}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &batch.Error{Code: "request_failed", Err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = string(data)
+		}
+		if apiErr.Code == "" {
+			apiErr.Code = fmt.Sprintf("http_%d", resp.StatusCode)
+		}
+		return nil, &batch.Error{Code: apiErr.Code, Err: errors.New(apiErr.Error)}
+	}
+
+	return data, nil
+}
+
+// completedLines reads the lines that already succeeded from a previous
+// output file, if there is one.
+func completedLines(path string) (map[int]bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return batch.Completed(f)
+}
+
+// openForAppend opens the output file for appending, first ending a
+// truncated last record so new records start on their own line.
+func openForAppend(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			if _, err := f.Write([]byte("\n")); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
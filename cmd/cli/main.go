@@ -31,17 +31,30 @@ type DialogueResponse struct {
 }
 
 type DialogueExchange struct {
-	Character string `json:"character"`
-	Line      string `json:"line"`
+	Type          string `json:"type"`
+	Character     string `json:"character"`
+	Line          string `json:"line"`
+	Parenthetical string `json:"parenthetical,omitempty"`
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "batch" {
+		runBatch(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "subtitles" {
+		runSubtitles(os.Args[2:])
+		return
+	}
+
 	// Define command line flags
 	scenario := flag.String("scenario", "", "The scenario for the dialogue")
 	style := flag.String("style", "", "The style of the dialogue (e.g., noir, comedy, drama)")
 	tone := flag.String("tone", "", "The emotional tone of the dialogue")
 	exchanges := flag.Int("exchanges", 5, "Number of dialogue exchanges to generate")
 	apiURL := flag.String("api", "http://localhost:8080", "API base URL")
+	stream := flag.Bool("stream", false, "Print dialogue lines as they are generated")
 	
 	flag.Parse()
 
@@ -82,6 +95,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *stream {
+		fmt.Println("\n===== GENERATED DIALOGUE =====")
+		fmt.Println("Scenario:", *scenario)
+		fmt.Println()
+		if err := streamDialogue(*apiURL, jsonData); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Send request to API
 	resp, err := http.Post(*apiURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -117,6 +141,22 @@ func main() {
 	fmt.Println()
 
 	for _, exchange := range dialogue.Exchanges {
+		printExchange(exchange)
+	}
+}
+
+// printExchange prints one beat of a scene in a screenplay-like layout.
+func printExchange(exchange DialogueExchange) {
+	switch exchange.Type {
+	case "action":
+		fmt.Printf("  [%s]\n\n", exchange.Line)
+	case "transition":
+		fmt.Printf("%60s\n\n", exchange.Line)
+	default:
+		if exchange.Parenthetical != "" {
+			fmt.Printf("%s: (%s) %s\n\n", exchange.Character, exchange.Parenthetical, exchange.Line)
+			return
+		}
 		fmt.Printf("%s: %s\n\n", exchange.Character, exchange.Line)
 	}
 }
\ No newline at end of file
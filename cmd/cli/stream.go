@@ -0,0 +1,94 @@
+// cmd/cli/stream.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// streamDialogue calls the streaming endpoint and prints each exchange as
+// soon as the server sends it.
+func streamDialogue(apiURL string, jsonData []byte) error {
+	resp, err := http.Post(apiURL+"/api/generate/stream", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error: %s", string(body))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var event string
+	var last DialogueExchange
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := []byte(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			done, err := handleStreamEvent(event, data, &last)
+			if err != nil || done {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+	return errors.New("stream ended before the dialogue was complete")
+}
+
+// handleStreamEvent prints a single event and reports whether the stream is
+// finished. last is the most recently printed exchange, which an
+// "exchange_update" extends.
+func handleStreamEvent(event string, data []byte, last *DialogueExchange) (bool, error) {
+	switch event {
+	case "exchange":
+		var exchange DialogueExchange
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			return false, fmt.Errorf("parsing exchange: %w", err)
+		}
+		printExchange(exchange)
+		*last = exchange
+	case "exchange_update":
+		var exchange DialogueExchange
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			return false, fmt.Errorf("parsing exchange: %w", err)
+		}
+		// Print only what the continuation added when it can be told apart
+		if added, ok := strings.CutPrefix(exchange.Line, last.Line); ok && exchange.Character == last.Character {
+			if added = strings.TrimSpace(added); added != "" {
+				fmt.Printf("    %s\n\n", added)
+			}
+		} else {
+			printExchange(exchange)
+		}
+		*last = exchange
+	case "done":
+		var dialogue DialogueResponse
+		if err := json.Unmarshal(data, &dialogue); err != nil {
+			return false, fmt.Errorf("parsing response: %w", err)
+		}
+		fmt.Printf("===== %d EXCHANGES =====\n", len(dialogue.Exchanges))
+		return true, nil
+	case "error":
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return false, fmt.Errorf("API error: %s", apiErr.Error)
+	}
+	return false, nil
+}
@@ -0,0 +1,129 @@
+// cmd/cli/subtitles.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runSubtitles implements the "subtitles" subcommand: it exports SRT or
+// WebVTT captions for a saved dialogue, or for a dialogue or render response
+// saved as JSON, and writes them to a file.
+func runSubtitles(args []string) {
+	fs := flag.NewFlagSet("subtitles", flag.ExitOnError)
+	dialogueID := fs.Int("dialogue", 0, "ID of a saved dialogue")
+	inputPath := fs.String("input", "", "JSON file with a dialogue, or a render response with a manifest")
+	format := fs.String("format", "srt", "Subtitle format: srt or vtt")
+	outputPath := fs.String("output", "", "File to write (default: named after the dialogue or input)")
+	timing := fs.String("timing", "estimate", "For saved dialogues: estimate timings, or voice the dialogue and use the audio's (audio)")
+	wpm := fs.Int("wpm", 0, "Speaking rate for estimated timings (default 150)")
+	gap := fs.Int("gap", -1, "Pause between lines in milliseconds (default 500)")
+	maxLineLength := fs.Int("max-line-length", 0, "Most characters per caption row (default 42)")
+	apiURL := fs.String("api", "http://localhost:8080", "API base URL")
+	fs.Parse(args)
+
+	if (*dialogueID == 0) == (*inputPath == "") {
+		fmt.Println("Error: exactly one of -dialogue or -input is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var req *http.Request
+	var err error
+	if *dialogueID != 0 {
+		query := url.Values{"format": {*format}, "timing": {*timing}}
+		if *wpm > 0 {
+			query.Set("wpm", strconv.Itoa(*wpm))
+		}
+		if *gap >= 0 {
+			query.Set("gapMs", strconv.Itoa(*gap))
+		}
+		if *maxLineLength > 0 {
+			query.Set("maxLineLength", strconv.Itoa(*maxLineLength))
+		}
+		req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/dialogues/%d/subtitles?%s", *apiURL, *dialogueID, query.Encode()), nil)
+		if *outputPath == "" {
+			*outputPath = fmt.Sprintf("dialogue-%d.%s", *dialogueID, *format)
+		}
+	} else {
+		var body []byte
+		body, err = subtitleRequestBody(*inputPath, *format, *wpm, *gap, *maxLineLength)
+		if err != nil {
+			fmt.Println("Error reading input:", err)
+			os.Exit(1)
+		}
+		req, err = http.NewRequest("POST", *apiURL+"/api/dialogues/subtitles", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if *outputPath == "" {
+			*outputPath = strings.TrimSuffix(*inputPath, filepath.Ext(*inputPath)) + "." + *format
+		}
+	}
+	if err != nil {
+		fmt.Println("Error creating request:", err)
+		os.Exit(1)
+	}
+
+	// Audio timings mean voicing the whole dialogue first
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("Error sending request:", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("Error reading response:", err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Error from API (status %d): %s\n", resp.StatusCode, string(data))
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(*outputPath, data, 0644); err != nil {
+		fmt.Println("Error writing subtitles:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote", *outputPath)
+}
+
+// subtitleRequestBody adds the export options to the JSON in the input file.
+func subtitleRequestBody(path, format string, wpm, gap, maxLineLength int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	body["format"] = format
+	if wpm > 0 {
+		body["wpm"] = wpm
+	}
+	if gap >= 0 {
+		body["gapMs"] = gap
+	}
+	if maxLineLength > 0 {
+		body["maxLineLength"] = maxLineLength
+	}
+	// The audio itself is not needed for captions
+	delete(body, "audio")
+
+	return json.Marshal(body)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -43,14 +45,51 @@ func InitDB() {
 
 // Character models and operations
 type Character struct {
-	ID     int      `json:"id"`
-	Name   string   `json:"name"`
-	Type   string   `json:"type"`
-	Traits []string `json:"traits"`
+	ID     int           `json:"id"`
+	Name   string        `json:"name"`
+	Type   string        `json:"type"`
+	Traits []string      `json:"traits"`
+	Voice  *VoiceProfile `json:"voice,omitempty"`
+}
+
+// VoiceProfile is how a character sounds. Unset fields fall back to the
+// defaults for the character's type, then to the provider's own defaults.
+type VoiceProfile struct {
+	Provider        string   `json:"provider,omitempty"`
+	VoiceID         string   `json:"voiceId,omitempty"`
+	Stability       *float64 `json:"stability,omitempty"`
+	SimilarityBoost *float64 `json:"similarityBoost,omitempty"`
+	// SpeakingRate multiplies the normal rate of speech
+	SpeakingRate *float64 `json:"speakingRate,omitempty"`
+	// Pitch shifts the voice in semitones
+	Pitch *float64 `json:"pitch,omitempty"`
+}
+
+// DefaultVoiceType is the voice_defaults entry used for characters whose type
+// has none of its own
+const DefaultVoiceType = "default"
+
+const characterColumns = "id, name, type, traits, voice"
+
+func scanCharacter(row interface{ Scan(dest ...any) error }) (*Character, error) {
+	var c Character
+	var traitsJSON, voiceJSON []byte
+	if err := row.Scan(&c.ID, &c.Name, &c.Type, &traitsJSON, &voiceJSON); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(traitsJSON, &c.Traits); err != nil {
+		return nil, err
+	}
+	if len(voiceJSON) > 0 {
+		if err := json.Unmarshal(voiceJSON, &c.Voice); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
 }
 
 func GetCharacters() ([]Character, error) {
-	rows, err := DB.Query("SELECT id, name, type, traits FROM characters")
+	rows, err := DB.Query("SELECT " + characterColumns + " FROM characters")
 	if err != nil {
 		return nil, err
 	}
@@ -58,35 +97,130 @@ func GetCharacters() ([]Character, error) {
 
 	var characters []Character
 	for rows.Next() {
-		var c Character
-		var traitsJSON []byte
-		if err := rows.Scan(&c.ID, &c.Name, &c.Type, &traitsJSON); err != nil {
+		c, err := scanCharacter(rows)
+		if err != nil {
 			return nil, err
 		}
-		if err := json.Unmarshal(traitsJSON, &c.Traits); err != nil {
-			return nil, err
-		}
-		characters = append(characters, c)
+		characters = append(characters, *c)
 	}
 
 	return characters, nil
 }
 
+func GetCharacter(id int) (*Character, error) {
+	return scanCharacter(DB.QueryRow("SELECT "+characterColumns+" FROM characters WHERE id = $1", id))
+}
+
+// GetCharacterByName finds a character by name, ignoring case. If several
+// share the name, the oldest wins.
+func GetCharacterByName(name string) (*Character, error) {
+	return scanCharacter(DB.QueryRow(
+		"SELECT "+characterColumns+" FROM characters WHERE LOWER(name) = LOWER($1) ORDER BY id LIMIT 1",
+		name,
+	))
+}
+
 func CreateCharacter(character Character) (int, error) {
 	traitsJSON, err := json.Marshal(character.Traits)
 	if err != nil {
 		return 0, err
 	}
+	voiceJSON, err := marshalVoiceProfile(character.Voice)
+	if err != nil {
+		return 0, err
+	}
 
 	var id int
 	err = DB.QueryRow(
-		"INSERT INTO characters (name, type, traits) VALUES ($1, $2, $3) RETURNING id",
-		character.Name, character.Type, traitsJSON,
+		"INSERT INTO characters (name, type, traits, voice) VALUES ($1, $2, $3, $4) RETURNING id",
+		character.Name, character.Type, traitsJSON, voiceJSON,
 	).Scan(&id)
 
 	return id, err
 }
 
+// SetCharacterVoice replaces a character's voice profile; nil clears it.
+func SetCharacterVoice(id int, voice *VoiceProfile) error {
+	voiceJSON, err := marshalVoiceProfile(voice)
+	if err != nil {
+		return err
+	}
+
+	result, err := DB.Exec("UPDATE characters SET voice = $2 WHERE id = $1", id, voiceJSON)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// marshalVoiceProfile encodes a profile for a nullable JSONB column.
+func marshalVoiceProfile(voice *VoiceProfile) ([]byte, error) {
+	if voice == nil {
+		return nil, nil
+	}
+	return json.Marshal(voice)
+}
+
+// GetVoiceDefaults returns the voice defaults by character type.
+func GetVoiceDefaults() (map[string]VoiceProfile, error) {
+	rows, err := DB.Query("SELECT character_type, profile FROM voice_defaults")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defaults := make(map[string]VoiceProfile)
+	for rows.Next() {
+		var characterType string
+		var profileJSON []byte
+		if err := rows.Scan(&characterType, &profileJSON); err != nil {
+			return nil, err
+		}
+		var profile VoiceProfile
+		if err := json.Unmarshal(profileJSON, &profile); err != nil {
+			return nil, err
+		}
+		defaults[characterType] = profile
+	}
+
+	return defaults, rows.Err()
+}
+
+// GetVoiceDefault returns the voice defaults for a character type.
+func GetVoiceDefault(characterType string) (*VoiceProfile, error) {
+	var profileJSON []byte
+	err := DB.QueryRow(
+		"SELECT profile FROM voice_defaults WHERE character_type = LOWER($1)", characterType,
+	).Scan(&profileJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile VoiceProfile
+	if err := json.Unmarshal(profileJSON, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SetVoiceDefault stores the voice defaults for a character type.
+func SetVoiceDefault(characterType string, profile VoiceProfile) error {
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(
+		`INSERT INTO voice_defaults (character_type, profile) VALUES (LOWER($1), $2)
+		ON CONFLICT (character_type) DO UPDATE SET profile = EXCLUDED.profile`,
+		characterType, profileJSON,
+	)
+	return err
+}
+
 // Reference Dialogue models and operations
 type ReferenceDialogue struct {
 	ID         int      `json:"id"`
@@ -178,4 +312,333 @@ func GetGeneratedDialogues() ([]GeneratedDialogue, error) {
 	}
 
 	return dialogues, nil
-}
\ No newline at end of file
+}
+
+func GetGeneratedDialogue(id int) (*GeneratedDialogue, error) {
+	var d GeneratedDialogue
+	err := DB.QueryRow(
+		"SELECT id, scenario, characters, content, created_at FROM dialogues WHERE id = $1", id,
+	).Scan(&d.ID, &d.Scenario, &d.Characters, &d.Content, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// ReviseGeneratedDialogue replaces a saved dialogue's content, keeping the
+// previous content as a revision. It returns the revision ID.
+func ReviseGeneratedDialogue(id int, content json.RawMessage, note string) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var revisionID int
+	err = tx.QueryRow(
+		"INSERT INTO dialogue_revisions (dialogue_id, content, note) SELECT id, content, $2 FROM dialogues WHERE id = $1 RETURNING id",
+		id, note,
+	).Scan(&revisionID)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("UPDATE dialogues SET content = $2 WHERE id = $1", id, content); err != nil {
+		return 0, err
+	}
+
+	return revisionID, tx.Commit()
+}
+
+// GetCachedGeneration returns an unexpired cached generation response and
+// when it expires, or sql.ErrNoRows.
+func GetCachedGeneration(key string) (json.RawMessage, time.Time, error) {
+	var response json.RawMessage
+	var expiresAt time.Time
+	err := DB.QueryRow(
+		"SELECT response, expires_at FROM generation_cache WHERE key = $1 AND expires_at > NOW()", key,
+	).Scan(&response, &expiresAt)
+	return response, expiresAt, err
+}
+
+// PutCachedGeneration stores a generation response until expiresAt,
+// replacing any earlier response under the same key.
+func PutCachedGeneration(key string, response json.RawMessage, expiresAt time.Time) error {
+	_, err := DB.Exec(
+		`INSERT INTO generation_cache (key, response, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET response = EXCLUDED.response, created_at = NOW(), expires_at = EXCLUDED.expires_at`,
+		key, response, expiresAt,
+	)
+	return err
+}
+
+// DeleteExpiredGenerations removes expired cache entries and returns how many
+// were removed.
+func DeleteExpiredGenerations() (int64, error) {
+	result, err := DB.Exec("DELETE FROM generation_cache WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Generation job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// GenerationJob is a queued or finished asynchronous generation.
+type GenerationJob struct {
+	ID             int
+	Status         string
+	Request        json.RawMessage
+	AutoSave       bool
+	APIKey         string // fingerprint of the API key that queued the job
+	CompletedTakes int
+	TotalTakes     int
+	Result         json.RawMessage
+	Error          string
+	ErrorCode      string
+	DialogueID     *int
+	CreatedAt      time.Time
+	StartedAt      *time.Time
+	FinishedAt     *time.Time
+}
+
+const generationJobColumns = `id, status, request, auto_save, api_key, completed_takes, total_takes, result,
+	COALESCE(error, ''), COALESCE(error_code, ''), dialogue_id, created_at, started_at, finished_at`
+
+func scanGenerationJob(row *sql.Row) (*GenerationJob, error) {
+	var j GenerationJob
+	var result []byte // NULL until the job succeeds
+	err := row.Scan(&j.ID, &j.Status, &j.Request, &j.AutoSave, &j.APIKey, &j.CompletedTakes, &j.TotalTakes, &result,
+		&j.Error, &j.ErrorCode, &j.DialogueID, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Result = result
+	return &j, nil
+}
+
+func CreateGenerationJob(request json.RawMessage, autoSave bool, apiKey string, totalTakes int) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		"INSERT INTO generation_jobs (request, auto_save, api_key, total_takes) VALUES ($1, $2, $3, $4) RETURNING id",
+		request, autoSave, apiKey, totalTakes,
+	).Scan(&id)
+
+	return id, err
+}
+
+func GetGenerationJob(id int) (*GenerationJob, error) {
+	return scanGenerationJob(DB.QueryRow("SELECT "+generationJobColumns+" FROM generation_jobs WHERE id = $1", id))
+}
+
+// ClaimGenerationJob marks the oldest queued job as running and returns it,
+// or returns sql.ErrNoRows when the queue is empty. Concurrent callers never
+// claim the same job. The claim is a lease the caller keeps with
+// HeartbeatGenerationJob.
+func ClaimGenerationJob() (*GenerationJob, error) {
+	return scanGenerationJob(DB.QueryRow(`UPDATE generation_jobs SET status = $1, started_at = NOW(), heartbeat_at = NOW()
+		WHERE id = (
+			SELECT id FROM generation_jobs WHERE status = $2 ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+generationJobColumns, JobRunning, JobQueued))
+}
+
+// HeartbeatGenerationJob renews the lease on a job claimed at startedAt. It
+// reports false if the job is no longer running under that claim, because it
+// was canceled or its lease expired and it was requeued.
+func HeartbeatGenerationJob(id int, startedAt time.Time) (bool, error) {
+	result, err := DB.Exec(
+		"UPDATE generation_jobs SET heartbeat_at = NOW() WHERE id = $1 AND status = $2 AND started_at = $3",
+		id, JobRunning, startedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RequeueExpiredGenerationJobs puts running jobs whose last heartbeat is older
+// than lease back in the queue, since the process running them has gone, and
+// returns how many there were.
+func RequeueExpiredGenerationJobs(lease time.Duration) (int64, error) {
+	result, err := DB.Exec(
+		`UPDATE generation_jobs SET status = $1, started_at = NULL, heartbeat_at = NULL, completed_takes = 0
+		WHERE status = $2 AND (heartbeat_at IS NULL OR heartbeat_at < NOW() - $3 * INTERVAL '1 millisecond')`,
+		JobQueued, JobRunning, lease.Milliseconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func UpdateGenerationJobProgress(id, completedTakes int) error {
+	_, err := DB.Exec(
+		"UPDATE generation_jobs SET completed_takes = $2 WHERE id = $1 AND status = $3",
+		id, completedTakes, JobRunning,
+	)
+	return err
+}
+
+// CompleteGenerationJob stores a running job's result. It does nothing if the
+// job was canceled meanwhile.
+func CompleteGenerationJob(id int, result json.RawMessage, dialogueID *int) error {
+	_, err := DB.Exec(
+		`UPDATE generation_jobs SET status = $2, result = $3, dialogue_id = $4, completed_takes = total_takes, finished_at = NOW()
+		WHERE id = $1 AND status = $5`,
+		id, JobSucceeded, result, dialogueID, JobRunning,
+	)
+	return err
+}
+
+// FailGenerationJob records why a running job failed. It does nothing if the
+// job was canceled meanwhile.
+func FailGenerationJob(id int, message, code string) error {
+	_, err := DB.Exec(
+		"UPDATE generation_jobs SET status = $2, error = $3, error_code = $4, finished_at = NOW() WHERE id = $1 AND status = $5",
+		id, JobFailed, message, code, JobRunning,
+	)
+	return err
+}
+
+// CancelGenerationJob cancels a queued or running job. It reports false if
+// the job had already finished.
+func CancelGenerationJob(id int) (bool, error) {
+	result, err := DB.Exec(
+		"UPDATE generation_jobs SET status = $2, finished_at = NOW() WHERE id = $1 AND status IN ($3, $4)",
+		id, JobCanceled, JobQueued, JobRunning,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UsageRecord is the token usage and cost of one generation request.
+type UsageRecord struct {
+	Endpoint         string
+	Provider         string
+	Model            string
+	APIKey           string // fingerprint of the caller's API key, or ""
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	Estimated        bool
+	Cost             *float64 // nil when the model has no configured price
+}
+
+func RecordUsage(r UsageRecord) error {
+	_, err := DB.Exec(
+		`INSERT INTO usage_records (endpoint, provider, model, api_key, calls, prompt_tokens, completion_tokens, estimated, cost)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		r.Endpoint, r.Provider, r.Model, r.APIKey, r.Calls, r.PromptTokens, r.CompletionTokens, r.Estimated, r.Cost,
+	)
+	return err
+}
+
+// UsageTotal sums usage records over a group. Fields the totals are not
+// grouped by are empty.
+type UsageTotal struct {
+	Model            string  `json:"model,omitempty"`
+	Day              string  `json:"day,omitempty"`
+	APIKey           string  `json:"apiKey,omitempty"`
+	Requests         int     `json:"requests"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// usageGroupColumns are the columns usage can be grouped by
+var usageGroupColumns = map[string]string{
+	"model": "model",
+	"day":   "TO_CHAR(created_at, 'YYYY-MM-DD')",
+	"key":   "api_key",
+}
+
+var usageGroupPositions = map[string]int{"model": 0, "day": 1, "key": 2}
+
+// GetUsageTotals sums usage recorded from the start of day from to the end
+// of day to (both "YYYY-MM-DD"), grouped by any of "model", "day" and "key".
+func GetUsageTotals(from, to string, groupBy []string) ([]UsageTotal, error) {
+	columns := []string{"''", "''", "''"}
+	var groups []string
+	for _, g := range groupBy {
+		column, ok := usageGroupColumns[g]
+		if !ok {
+			return nil, fmt.Errorf("unknown usage group %q", g)
+		}
+		columns[usageGroupPositions[g]] = column
+		groups = append(groups, column)
+	}
+
+	query := fmt.Sprintf(`SELECT %s, %s, %s, COUNT(*), COALESCE(SUM(calls), 0), COALESCE(SUM(prompt_tokens), 0),
+		COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(cost), 0)
+		FROM usage_records WHERE created_at >= $1::date AND created_at < $2::date + 1`,
+		columns[0], columns[1], columns[2])
+	if len(groups) > 0 {
+		query += " GROUP BY " + strings.Join(groups, ", ") + " ORDER BY " + strings.Join(groups, ", ")
+	}
+
+	rows, err := DB.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []UsageTotal
+	for rows.Next() {
+		var t UsageTotal
+		if err := rows.Scan(&t.Model, &t.Day, &t.APIKey, &t.Requests, &t.Calls, &t.PromptTokens, &t.CompletionTokens, &t.Cost); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+
+	return totals, rows.Err()
+}
+
+// AudioClip describes a synthesized clip kept in the audio cache.
+type AudioClip struct {
+	Key         string
+	Provider    string
+	VoiceID     string
+	Settings    json.RawMessage
+	Text        string
+	ContentType string
+	SizeBytes   int
+}
+
+func PutAudioClip(clip AudioClip) error {
+	_, err := DB.Exec(
+		`INSERT INTO audio_clips (key, provider, voice_id, settings, text, content_type, size_bytes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (key) DO UPDATE SET content_type = EXCLUDED.content_type, size_bytes = EXCLUDED.size_bytes,
+			created_at = CURRENT_TIMESTAMP, last_used_at = CURRENT_TIMESTAMP`,
+		clip.Key, clip.Provider, clip.VoiceID, clip.Settings, clip.Text, clip.ContentType, clip.SizeBytes,
+	)
+	return err
+}
+
+// TouchAudioClip counts a cache hit on a clip.
+func TouchAudioClip(key string) error {
+	_, err := DB.Exec(
+		"UPDATE audio_clips SET hits = hits + 1, last_used_at = CURRENT_TIMESTAMP WHERE key = $1", key,
+	)
+	return err
+}
+
+func DeleteAudioClip(key string) error {
+	_, err := DB.Exec("DELETE FROM audio_clips WHERE key = $1", key)
+	return err
+}
@@ -0,0 +1,3 @@
+-- Adds voice profiles to databases created before characters had them.
+-- New databases get the column from schema.sql.
+ALTER TABLE characters ADD COLUMN IF NOT EXISTS voice JSONB;
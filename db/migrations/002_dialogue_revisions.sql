@@ -0,0 +1,9 @@
+-- Adds the dialogue_revisions table to databases created before dialogues
+-- kept a history. New databases get it from schema.sql.
+CREATE TABLE IF NOT EXISTS dialogue_revisions (
+    id SERIAL PRIMARY KEY,
+    dialogue_id INTEGER NOT NULL REFERENCES dialogues(id) ON DELETE CASCADE,
+    content JSONB NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
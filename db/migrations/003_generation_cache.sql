@@ -0,0 +1,8 @@
+-- Adds the generation_cache table to databases created before responses
+-- were cached. New databases get it from schema.sql.
+CREATE TABLE IF NOT EXISTS generation_cache (
+    key CHAR(64) PRIMARY KEY,
+    response JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,20 @@
+-- Adds the generation_jobs table to databases created before generations
+-- could be queued. New databases get it from schema.sql.
+CREATE TABLE IF NOT EXISTS generation_jobs (
+    id SERIAL PRIMARY KEY,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    request JSONB NOT NULL,
+    auto_save BOOLEAN NOT NULL DEFAULT FALSE,
+    api_key VARCHAR(64) NOT NULL DEFAULT '',
+    completed_takes INTEGER NOT NULL DEFAULT 0,
+    total_takes INTEGER NOT NULL DEFAULT 1,
+    result JSONB,
+    error TEXT,
+    error_code VARCHAR(50),
+    dialogue_id INTEGER REFERENCES dialogues(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS generation_jobs_status_idx ON generation_jobs (status, id);
@@ -0,0 +1,17 @@
+-- Adds the usage_records table to databases created before usage was
+-- recorded. New databases get it from schema.sql.
+CREATE TABLE IF NOT EXISTS usage_records (
+    id SERIAL PRIMARY KEY,
+    endpoint VARCHAR(255) NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    model VARCHAR(255) NOT NULL DEFAULT '',
+    api_key VARCHAR(64) NOT NULL DEFAULT '',
+    calls INTEGER NOT NULL,
+    prompt_tokens INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    estimated BOOLEAN NOT NULL,
+    cost NUMERIC(14, 6),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS usage_records_created_at_idx ON usage_records (created_at);
@@ -0,0 +1,14 @@
+-- Adds the voice_defaults table and its seed rows to databases created
+-- before voices were configurable. Existing rows are left alone.
+CREATE TABLE IF NOT EXISTS voice_defaults (
+    character_type VARCHAR(50) PRIMARY KEY,
+    profile JSONB NOT NULL
+);
+
+INSERT INTO voice_defaults (character_type, profile) VALUES
+    ('hero', '{"provider": "elevenlabs", "voiceId": "21m00Tcm4TlvDq8ikWAM"}'),
+    ('villain', '{"provider": "elevenlabs", "voiceId": "AZnzlk1XvdvUeBnXmlld"}'),
+    ('sidekick', '{"provider": "elevenlabs", "voiceId": "EXAVITQu4vr4xnSDxMaL"}'),
+    ('detective', '{"provider": "elevenlabs", "voiceId": "MF3mGyEYCl7XYWbV9V6O"}'),
+    ('default', '{"provider": "elevenlabs", "voiceId": "EXAVITQu4vr4xnSDxMaL"}')
+ON CONFLICT (character_type) DO NOTHING;
@@ -0,0 +1,14 @@
+-- Adds the audio_clips table to databases created before synthesized
+-- audio was cached. New databases get it from schema.sql.
+CREATE TABLE IF NOT EXISTS audio_clips (
+    key CHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    voice_id VARCHAR(255) NOT NULL,
+    settings JSONB NOT NULL,
+    text TEXT NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    hits INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- Adds job leases to databases created before running jobs sent heartbeats.
+-- Jobs running during the upgrade have no heartbeat and are requeued once.
+ALTER TABLE generation_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
@@ -2,7 +2,8 @@ CREATE TABLE characters (
     id SERIAL PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     type VARCHAR(50) NOT NULL,
-    traits JSONB NOT NULL
+    traits JSONB NOT NULL,
+    voice JSONB
 );
 
 CREATE TABLE dialogues (
@@ -19,4 +20,81 @@ CREATE TABLE reference_dialogues (
     characters JSONB NOT NULL,
     content TEXT NOT NULL,
     tags TEXT[] NOT NULL
-);
\ No newline at end of file
+);
+
+CREATE TABLE dialogue_revisions (
+    id SERIAL PRIMARY KEY,
+    dialogue_id INTEGER NOT NULL REFERENCES dialogues(id) ON DELETE CASCADE,
+    content JSONB NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE generation_cache (
+    key CHAR(64) PRIMARY KEY,
+    response JSONB NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE generation_jobs (
+    id SERIAL PRIMARY KEY,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    request JSONB NOT NULL,
+    auto_save BOOLEAN NOT NULL DEFAULT FALSE,
+    api_key VARCHAR(64) NOT NULL DEFAULT '',
+    completed_takes INTEGER NOT NULL DEFAULT 0,
+    total_takes INTEGER NOT NULL DEFAULT 1,
+    result JSONB,
+    error TEXT,
+    error_code VARCHAR(50),
+    dialogue_id INTEGER REFERENCES dialogues(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    heartbeat_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX generation_jobs_status_idx ON generation_jobs (status, id);
+
+CREATE TABLE usage_records (
+    id SERIAL PRIMARY KEY,
+    endpoint VARCHAR(255) NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    model VARCHAR(255) NOT NULL DEFAULT '',
+    api_key VARCHAR(64) NOT NULL DEFAULT '',
+    calls INTEGER NOT NULL,
+    prompt_tokens INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    estimated BOOLEAN NOT NULL,
+    cost NUMERIC(14, 6),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX usage_records_created_at_idx ON usage_records (created_at);
+
+CREATE TABLE voice_defaults (
+    character_type VARCHAR(50) PRIMARY KEY,
+    profile JSONB NOT NULL
+);
+
+INSERT INTO voice_defaults (character_type, profile) VALUES
+    ('hero', '{"provider": "elevenlabs", "voiceId": "21m00Tcm4TlvDq8ikWAM"}'),
+    ('villain', '{"provider": "elevenlabs", "voiceId": "AZnzlk1XvdvUeBnXmlld"}'),
+    ('sidekick', '{"provider": "elevenlabs", "voiceId": "EXAVITQu4vr4xnSDxMaL"}'),
+    ('detective', '{"provider": "elevenlabs", "voiceId": "MF3mGyEYCl7XYWbV9V6O"}'),
+    ('default', '{"provider": "elevenlabs", "voiceId": "EXAVITQu4vr4xnSDxMaL"}')
+ON CONFLICT (character_type) DO NOTHING;
+
+CREATE TABLE audio_clips (
+    key CHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    voice_id VARCHAR(255) NOT NULL,
+    settings JSONB NOT NULL,
+    text TEXT NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    hits INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
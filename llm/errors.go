@@ -0,0 +1,127 @@
+// llm/errors.go
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes describing why a provider call failed
+const (
+	CodeModelLoading = "model_loading"        // the model is cold-starting; retry later
+	CodeRateLimited  = "rate_limited"         // too many requests; retry later
+	CodeUnavailable  = "upstream_unavailable" // the API could not be reached
+	CodeTimeout      = "upstream_timeout"     // the call did not finish in time
+	CodeUpstream     = "upstream_error"       // the API failed or returned an unusable reply
+	CodeRejected     = "upstream_rejected"    // the API refused the request
+)
+
+// Error is a failed provider call. Code is one of the Code constants.
+type Error struct {
+	Code    string
+	Message string
+	// Status is the HTTP status the API replied with, or 0 without a reply
+	Status int
+	// RetryAfter is how long the API asked callers to wait, if it said
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the same call may succeed if retried.
+func (e *Error) Temporary() bool {
+	switch e.Code {
+	case CodeModelLoading, CodeRateLimited, CodeUnavailable, CodeTimeout:
+		return true
+	case CodeUpstream:
+		return e.Status >= 500
+	}
+	return false
+}
+
+// statusError describes a non-200 reply from api. It reads the Retry-After
+// header and the "estimated_time" HuggingFace reports while a model loads.
+func statusError(api string, resp *http.Response, body []byte) *Error {
+	detail := strings.TrimSpace(string(body))
+	if detail == "" {
+		detail = http.StatusText(resp.StatusCode)
+	}
+	e := &Error{
+		Code:       CodeUpstream,
+		Message:    fmt.Sprintf("%s error (status %d): %s", api, resp.StatusCode, detail),
+		Status:     resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var loading struct {
+		EstimatedTime float64 `json:"estimated_time"`
+	}
+	json.Unmarshal(body, &loading)
+
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable && loading.EstimatedTime > 0:
+		e.Code = CodeModelLoading
+		if e.RetryAfter == 0 {
+			e.RetryAfter = time.Duration(loading.EstimatedTime * float64(time.Second))
+		}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		e.Code = CodeRateLimited
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusGatewayTimeout:
+		e.Code = CodeTimeout
+	case resp.StatusCode < 500:
+		e.Code = CodeRejected
+	}
+	return e
+}
+
+// connectError describes a call to api that got no reply.
+func connectError(api string, err error) *Error {
+	code := CodeUnavailable
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		code = CodeTimeout
+	}
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf("failed to connect to %s", api),
+		Err:     err,
+	}
+}
+
+// replyError describes a reply from api that could not be used.
+func replyError(message string, err error) *Error {
+	return &Error{Code: CodeUpstream, Message: message, Err: err}
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
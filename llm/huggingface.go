@@ -0,0 +1,250 @@
+// llm/huggingface.go
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultHuggingFaceModel = "meta-llama/Meta-Llama-3.2-3B-Instruct"
+
+// HuggingFace API request structure
+type huggingFaceRequest struct {
+	Inputs     string `json:"inputs"`
+	Parameters struct {
+		Temperature       float64  `json:"temperature"`
+		MaxNewTokens      int      `json:"max_new_tokens"`
+		ReturnFullText    bool     `json:"return_full_text"`
+		TopP              *float64 `json:"top_p,omitempty"`
+		RepetitionPenalty *float64 `json:"repetition_penalty,omitempty"`
+		Stop              []string `json:"stop,omitempty"`
+		Seed              *int64   `json:"seed,omitempty"`
+	} `json:"parameters"`
+	Stream bool `json:"stream,omitempty"`
+}
+
+// HuggingFace API response structure
+type huggingFaceResponse struct {
+	GeneratedText string              `json:"generated_text"`
+	Details       *huggingFaceDetails `json:"details,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// HuggingFace streaming event structure
+type huggingFaceStreamEvent struct {
+	Token struct {
+		Text    string `json:"text"`
+		Special bool   `json:"special"`
+	} `json:"token"`
+	// Details arrive with the last token
+	Details *huggingFaceDetails `json:"details,omitempty"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// huggingFaceDetails is the generation summary text-generation-inference
+// adds to the final reply.
+type huggingFaceDetails struct {
+	GeneratedTokens int `json:"generated_tokens"`
+}
+
+func (d *huggingFaceDetails) usage() *Usage {
+	if d == nil || d.GeneratedTokens == 0 {
+		return nil
+	}
+	return &Usage{CompletionTokens: d.GeneratedTokens}
+}
+
+// HuggingFace talks to the HuggingFace Inference API.
+type HuggingFace struct {
+	APIKey  string
+	ModelID string
+	BaseURL string
+	Client  *http.Client
+
+	// Template overrides the chat template picked from ModelID
+	Template *ChatTemplate
+}
+
+// NewHuggingFaceFromEnv configures a HuggingFace provider from
+// HUGGINGFACE_API_KEY, HUGGINGFACE_MODEL_ID and HUGGINGFACE_CHAT_TEMPLATE.
+// Without an explicit template one is chosen from the model ID.
+func NewHuggingFaceFromEnv() (*HuggingFace, error) {
+	modelID := os.Getenv("HUGGINGFACE_MODEL_ID")
+	if modelID == "" {
+		// Default to Llama 3.2 3B model if not specified
+		modelID = defaultHuggingFaceModel
+	}
+
+	h := &HuggingFace{
+		APIKey:  os.Getenv("HUGGINGFACE_API_KEY"),
+		ModelID: modelID,
+		BaseURL: "https://api-inference.huggingface.co/models",
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+
+	if name := os.Getenv("HUGGINGFACE_CHAT_TEMPLATE"); name != "" {
+		template, err := LookupChatTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		h.Template = &template
+	}
+
+	return h, nil
+}
+
+func (h *HuggingFace) Name() string {
+	return "huggingface"
+}
+
+func (h *HuggingFace) DefaultModel() string {
+	return h.ModelID
+}
+
+func (h *HuggingFace) Generate(ctx context.Context, req Request) (*Response, error) {
+	request, err := h.newRequest(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.Client.Do(request)
+	if err != nil {
+		return nil, connectError("HuggingFace API", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, replyError("failed to read response", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("HuggingFace API", resp, body)
+	}
+
+	// The API returns a list for most models but a single object for some
+	var hfResp []huggingFaceResponse
+	if err := json.Unmarshal(body, &hfResp); err != nil {
+		var singleResp huggingFaceResponse
+		if err := json.Unmarshal(body, &singleResp); err != nil {
+			return nil, replyError("failed to parse HuggingFace response", err)
+		}
+		hfResp = []huggingFaceResponse{singleResp}
+	}
+
+	if len(hfResp) == 0 || hfResp[0].GeneratedText == "" {
+		return nil, replyError("no response generated", nil)
+	}
+
+	return &Response{Text: hfResp[0].GeneratedText, Model: h.model(req), Usage: hfResp[0].Details.usage()}, nil
+}
+
+// Stream uses the text-generation-inference token stream, which the
+// Inference API serves as Server-Sent Events when "stream" is set.
+func (h *HuggingFace) Stream(ctx context.Context, req Request, onChunk func(string) error) (*Response, error) {
+	request, err := h.newRequest(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client timeout would cut off long streams; rely on ctx instead
+	client := *h.Client
+	client.Timeout = 0
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, connectError("HuggingFace API", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, statusError("HuggingFace API", resp, body)
+	}
+
+	var text strings.Builder
+	var usage *Usage
+	err = readSSE(resp.Body, func(data string) error {
+		var event huggingFaceStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return replyError("failed to parse HuggingFace stream event", err)
+		}
+		if event.Error != "" {
+			return replyError(fmt.Sprintf("HuggingFace API error: %s", event.Error), nil)
+		}
+		if u := event.Details.usage(); u != nil {
+			usage = u
+		}
+		if event.Token.Special {
+			return nil
+		}
+		text.WriteString(event.Token.Text)
+		return onChunk(event.Token.Text)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if text.Len() == 0 {
+		return nil, replyError("no response generated", nil)
+	}
+	return &Response{Text: text.String(), Model: h.model(req), Usage: usage}, nil
+}
+
+func (h *HuggingFace) newRequest(ctx context.Context, req Request, stream bool) (*http.Request, error) {
+	if h.APIKey == "" {
+		return nil, errors.New("HUGGINGFACE_API_KEY environment variable not set")
+	}
+
+	hfReq := huggingFaceRequest{
+		Inputs: h.chatTemplate(h.model(req)).Render(req.SystemPrompt, req.Prompt),
+		Stream: stream,
+	}
+	hfReq.Parameters.Temperature = req.Temperature
+	hfReq.Parameters.MaxNewTokens = req.MaxTokens
+	hfReq.Parameters.ReturnFullText = false
+	hfReq.Parameters.TopP = req.TopP
+	hfReq.Parameters.RepetitionPenalty = req.RepetitionPenalty
+	hfReq.Parameters.Stop = req.Stop
+	hfReq.Parameters.Seed = req.Seed
+
+	jsonData, err := json.Marshal(hfReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	hfURL := fmt.Sprintf("%s/%s", h.BaseURL, h.model(req))
+	request, err := http.NewRequestWithContext(ctx, "POST", hfURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.APIKey))
+
+	return request, nil
+}
+
+// model is the model a request runs on.
+func (h *HuggingFace) model(req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return h.ModelID
+}
+
+func (h *HuggingFace) chatTemplate(modelID string) ChatTemplate {
+	if h.Template != nil {
+		return *h.Template
+	}
+	return ChatTemplateForModel(modelID)
+}
@@ -0,0 +1,152 @@
+// llm/mock.go
+package llm
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var mockOpenings = []string{
+	"I didn't expect to find you here.",
+	"We don't have much time.",
+	"You know exactly why I came.",
+	"Tell me what really happened.",
+	"Keep your voice down.",
+	"That's not how I remember it.",
+	"Are you sure about this?",
+	"Then we do it my way.",
+	"Nobody leaves until we get answers.",
+	"I've been waiting a long time for this.",
+	"You should have told me sooner.",
+	"It's too late to turn back now.",
+}
+
+var mockFollowUps = []string{
+	"Think it over.",
+	"I mean it.",
+	"Don't make me ask twice.",
+	"Trust me on this.",
+	"We both know it.",
+	"",
+}
+
+// Mock is an offline provider that returns scripted or seeded dialogue, so the
+// service can run end to end without any outside model.
+type Mock struct {
+	// Script, when set, is returned verbatim for every request
+	Script string
+	Seed   int64
+}
+
+// NewMockFromEnv configures a mock provider. MOCK_LLM_SCRIPT names a file whose
+// contents are returned as the completion; otherwise lines are generated from
+// MOCK_LLM_SEED.
+func NewMockFromEnv() (*Mock, error) {
+	m := &Mock{Seed: 1}
+
+	if path := os.Getenv("MOCK_LLM_SCRIPT"); path != "" {
+		script, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MOCK_LLM_SCRIPT: %w", err)
+		}
+		m.Script = string(script)
+	}
+
+	if seed := os.Getenv("MOCK_LLM_SEED"); seed != "" {
+		s, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MOCK_LLM_SEED %q: %w", seed, err)
+		}
+		m.Seed = s
+	}
+
+	return m, nil
+}
+
+func (m *Mock) Name() string {
+	return "mock"
+}
+
+func (m *Mock) DefaultModel() string {
+	return "mock"
+}
+
+func (m *Mock) Generate(ctx context.Context, req Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if m.Script != "" {
+		return &Response{Text: m.Script, Model: m.model(req)}, nil
+	}
+	return &Response{Text: m.dialogue(req), Model: m.model(req)}, nil
+}
+
+// Stream delivers the completion word by word, like a real token stream.
+func (m *Mock) Stream(ctx context.Context, req Request, onChunk func(string) error) (*Response, error) {
+	resp, err := m.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := resp.Text
+	for rest != "" {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Keep the separator that follows each word with that word
+		end := strings.IndexAny(rest, " \n")
+		if end < 0 {
+			end = len(rest) - 1
+		}
+		if err := onChunk(rest[:end+1]); err != nil {
+			return nil, err
+		}
+		rest = rest[end+1:]
+	}
+	return resp, nil
+}
+
+func (m *Mock) model(req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return m.DefaultModel()
+}
+
+// dialogue renders deterministic "NAME: line" output. The same seed and
+// prompt always produce the same text; a request seed replaces the
+// configured one.
+func (m *Mock) dialogue(req Request) string {
+	speakers := req.Speakers
+	if len(speakers) == 0 {
+		speakers = []string{"Speaker 1", "Speaker 2"}
+	}
+	turns := req.Turns
+	if turns <= 0 {
+		turns = 5
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(req.SystemPrompt))
+	h.Write([]byte(req.Prompt))
+	seed := m.Seed
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+	rng := rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+
+	var sb strings.Builder
+	for i := 0; i < turns; i++ {
+		line := mockOpenings[rng.Intn(len(mockOpenings))]
+		if followUp := mockFollowUps[rng.Intn(len(mockFollowUps))]; followUp != "" {
+			line += " " + followUp
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s\n", speakers[i%len(speakers)], line))
+	}
+	return sb.String()
+}
@@ -0,0 +1,238 @@
+// llm/openai.go
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultOpenAIBaseURL = "http://localhost:8000/v1"
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+	Model       string        `json:"model,omitempty"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	Seed        *int64        `json:"seed,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+	// Not part of the OpenAI API, but accepted by llama.cpp and vLLM
+	RepetitionPenalty *float64 `json:"repetition_penalty,omitempty"`
+}
+
+type chatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type chatCompletionChunk struct {
+	Choices []struct {
+		Delta chatMessage `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// OpenAI talks to any server implementing the OpenAI /chat/completions API,
+// such as llama.cpp, vLLM or Ollama.
+type OpenAI struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	Client  *http.Client
+}
+
+// NewOpenAIFromEnv configures an OpenAI-compatible provider from
+// OPENAI_BASE_URL, OPENAI_API_KEY (optional) and OPENAI_MODEL.
+func NewOpenAIFromEnv() *OpenAI {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+
+	return &OpenAI{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  os.Getenv("OPENAI_API_KEY"),
+		Model:   os.Getenv("OPENAI_MODEL"),
+		Client: &http.Client{
+			// Local models on modest hardware can be slow
+			Timeout: 120 * time.Second,
+		},
+	}
+}
+
+func (o *OpenAI) Name() string {
+	return "openai"
+}
+
+func (o *OpenAI) DefaultModel() string {
+	return o.Model
+}
+
+func (o *OpenAI) Generate(ctx context.Context, req Request) (*Response, error) {
+	request, err := o.newRequest(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := o.Client.Do(request)
+	if err != nil {
+		return nil, connectError("chat completions API", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, replyError("failed to read response", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("chat completions API", resp, body)
+	}
+
+	var chatResp chatCompletionResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, replyError("failed to parse chat completions response", err)
+	}
+	if chatResp.Error != nil {
+		return nil, replyError(fmt.Sprintf("chat completions API error: %s", chatResp.Error.Message), nil)
+	}
+
+	if len(chatResp.Choices) == 0 || chatResp.Choices[0].Message.Content == "" {
+		return nil, replyError("no response generated", nil)
+	}
+
+	model := chatResp.Model
+	if model == "" {
+		model = o.model(req)
+	}
+	response := &Response{Text: chatResp.Choices[0].Message.Content, Model: model}
+	if chatResp.Usage != nil {
+		response.Usage = &Usage{
+			PromptTokens:     chatResp.Usage.PromptTokens,
+			CompletionTokens: chatResp.Usage.CompletionTokens,
+		}
+	}
+	return response, nil
+}
+
+// Stream requests a streamed completion and forwards each content delta.
+func (o *OpenAI) Stream(ctx context.Context, req Request, onChunk func(string) error) (*Response, error) {
+	request, err := o.newRequest(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client timeout would cut off long streams; rely on ctx instead
+	client := *o.Client
+	client.Timeout = 0
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, connectError("chat completions API", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, statusError("chat completions API", resp, body)
+	}
+
+	var text strings.Builder
+	err = readSSE(resp.Body, func(data string) error {
+		if data == "[DONE]" {
+			return io.EOF
+		}
+		var chunk chatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return replyError("failed to parse chat completions stream", err)
+		}
+		if chunk.Error != nil {
+			return replyError(fmt.Sprintf("chat completions API error: %s", chunk.Error.Message), nil)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			return nil
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+		return onChunk(chunk.Choices[0].Delta.Content)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if text.Len() == 0 {
+		return nil, replyError("no response generated", nil)
+	}
+	return &Response{Text: text.String(), Model: o.model(req)}, nil
+}
+
+func (o *OpenAI) newRequest(ctx context.Context, req Request, stream bool) (*http.Request, error) {
+	chatReq := chatCompletionRequest{
+		Model:             o.model(req),
+		Messages:          chatMessages(req),
+		Temperature:       req.Temperature,
+		MaxTokens:         req.MaxTokens,
+		TopP:              req.TopP,
+		Stop:              req.Stop,
+		Seed:              req.Seed,
+		Stream:            stream,
+		RepetitionPenalty: req.RepetitionPenalty,
+	}
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", o.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", o.APIKey))
+	}
+
+	return request, nil
+}
+
+// model is the model a request runs on. It may be empty for servers that
+// host a single model.
+func (o *OpenAI) model(req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return o.Model
+}
+
+// chatMessages converts a request into system and user chat messages.
+func chatMessages(req Request) []chatMessage {
+	var messages []chatMessage
+	if req.SystemPrompt != "" {
+		messages = append(messages, chatMessage{Role: "system", Content: req.SystemPrompt})
+	}
+	return append(messages, chatMessage{Role: "user", Content: req.Prompt})
+}
@@ -0,0 +1,88 @@
+// llm/provider.go
+package llm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Provider generates a text completion for a prompt. Each implementation wraps
+// one inference backend so callers never depend on a vendor's API shape.
+type Provider interface {
+	Name() string
+	// DefaultModel is the model used for requests that do not name one. It
+	// may be empty when the backend serves a single unnamed model.
+	DefaultModel() string
+	Generate(ctx context.Context, req Request) (*Response, error)
+}
+
+// Request is a vendor-neutral generation request. Optional sampling
+// parameters are nil or empty to use the backend's defaults.
+type Request struct {
+	SystemPrompt string
+	Prompt       string
+	// Model overrides the provider's configured model
+	Model             string
+	Temperature       float64
+	MaxTokens         int
+	TopP              *float64
+	RepetitionPenalty *float64
+	Stop              []string
+	// Seed makes sampling reproducible on backends that support it
+	Seed *int64
+
+	// Speakers and Turns describe the expected dialogue. Real models read
+	// this from the prompt; offline providers use it to shape their output.
+	Speakers []string
+	Turns    int
+}
+
+// Response is the text produced by a provider and the model that wrote it.
+type Response struct {
+	Text  string
+	Model string
+	// Usage holds the token counts the backend reported, if it reported any
+	Usage *Usage
+}
+
+// Usage counts the tokens a call used. Counts a backend did not report are 0.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// NewFromEnv returns the provider selected by LLM_PROVIDER: "huggingface"
+// (default), "openai" for any OpenAI-compatible chat completions server, or
+// "mock" for offline development and tests. Remote providers retry temporary
+// failures; see Retry.
+func NewFromEnv() (Provider, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("LLM_PROVIDER")))
+	switch name {
+	case "", "huggingface", "hf":
+		hf, err := NewHuggingFaceFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return withRetry(hf)
+	case "openai":
+		return withRetry(NewOpenAIFromEnv())
+	case "mock":
+		mock, err := NewMockFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return mock, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", name)
+	}
+}
+
+func withRetry(p Provider) (Provider, error) {
+	r, err := NewRetryFromEnv(p)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
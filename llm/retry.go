@@ -0,0 +1,124 @@
+// llm/retry.go
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Retry wraps a provider and retries temporary failures with exponential
+// backoff and jitter. When the API says how long to wait, through Retry-After
+// or a loading model's estimated time, that wait replaces the backoff. Waits
+// never exceed MaxDelay, and a retry that could not start before the context
+// deadline is not attempted: the last error is returned at once.
+type Retry struct {
+	Provider
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewRetryFromEnv wraps p with the retry policy from LLM_MAX_ATTEMPTS
+// (default 4), LLM_RETRY_BASE_DELAY (default 500ms) and LLM_RETRY_MAX_DELAY
+// (default 30s).
+func NewRetryFromEnv(p Provider) (*Retry, error) {
+	r := &Retry{
+		Provider:    p,
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+	}
+
+	if v := os.Getenv("LLM_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid LLM_MAX_ATTEMPTS %q", v)
+		}
+		r.MaxAttempts = n
+	}
+	for name, d := range map[string]*time.Duration{
+		"LLM_RETRY_BASE_DELAY": &r.BaseDelay,
+		"LLM_RETRY_MAX_DELAY":  &r.MaxDelay,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*d = parsed
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Retry) Generate(ctx context.Context, req Request) (*Response, error) {
+	return r.do(ctx, func() (*Response, error) {
+		return r.Provider.Generate(ctx, req)
+	}, nil)
+}
+
+// Stream retries only until the first chunk has been delivered, since the
+// caller cannot take back text it has already received.
+func (r *Retry) Stream(ctx context.Context, req Request, onChunk func(string) error) (*Response, error) {
+	started := false
+	return r.do(ctx, func() (*Response, error) {
+		return Stream(ctx, r.Provider, req, func(chunk string) error {
+			started = true
+			return onChunk(chunk)
+		})
+	}, func() bool { return !started })
+}
+
+// do runs call until it succeeds, fails permanently or runs out of attempts.
+// canRetry, when set, can veto further attempts.
+func (r *Retry) do(ctx context.Context, call func() (*Response, error), canRetry func() bool) (*Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := call()
+		if err == nil {
+			return resp, nil
+		}
+
+		var llmErr *Error
+		if !errors.As(err, &llmErr) || !llmErr.Temporary() || attempt >= r.MaxAttempts {
+			return nil, err
+		}
+		if (canRetry != nil && !canRetry()) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := r.delay(attempt, llmErr.RetryAfter)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err
+		}
+		log.Printf("%s request failed (%s), retrying in %s: %v", r.Name(), llmErr.Code, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay is the wait before the next attempt: the API's hint if it gave one,
+// otherwise an exponential backoff with jitter over its upper half.
+func (r *Retry) delay(attempt int, hint time.Duration) time.Duration {
+	if hint > 0 {
+		return min(hint, r.MaxDelay)
+	}
+
+	backoff := r.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > r.MaxDelay {
+		backoff = r.MaxDelay
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
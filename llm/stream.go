@@ -0,0 +1,55 @@
+// llm/stream.go
+package llm
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+)
+
+// Streamer is implemented by providers that can deliver a completion
+// incrementally. onChunk receives each piece of text as it arrives; returning
+// an error from it aborts the stream.
+type Streamer interface {
+	Stream(ctx context.Context, req Request, onChunk func(string) error) (*Response, error)
+}
+
+// Stream generates with p, using its native streaming when it has one and
+// otherwise delivering the whole completion as a single chunk.
+func Stream(ctx context.Context, p Provider, req Request, onChunk func(string) error) (*Response, error) {
+	if s, ok := p.(Streamer); ok {
+		return s.Stream(ctx, req, onChunk)
+	}
+
+	resp, err := p.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := onChunk(resp.Text); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// readSSE calls onData with the payload of every "data:" field in a
+// Server-Sent Events body until the body ends or onData returns io.EOF.
+func readSSE(r io.Reader, onData func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if err := onData(data); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return scanner.Err()
+}
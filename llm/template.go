@@ -0,0 +1,128 @@
+// llm/template.go
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ChatTemplate renders a system and user message into the raw prompt format a
+// model family was fine-tuned on. Providers that accept raw text, such as the
+// HuggingFace Inference API, need this; chat completion APIs apply it server side.
+type ChatTemplate struct {
+	Name   string
+	render func(system, user string) string
+}
+
+// Render formats the messages, leaving the prompt open for the assistant's reply.
+func (t ChatTemplate) Render(system, user string) string {
+	return t.render(system, user)
+}
+
+var chatTemplates = map[string]ChatTemplate{
+	"llama3": {
+		Name: "llama3",
+		render: func(system, user string) string {
+			return "<|begin_of_text|>" +
+				"<|start_header_id|>system<|end_header_id|>\n\n" + system + "<|eot_id|>" +
+				"<|start_header_id|>user<|end_header_id|>\n\n" + user + "<|eot_id|>" +
+				"<|start_header_id|>assistant<|end_header_id|>\n\n"
+		},
+	},
+	"mistral": {
+		Name: "mistral",
+		// Mistral has no system role, so the system prompt leads the first
+		// turn; Llama 2 and CodeLlama chat models read the same [INST] turns
+		render: func(system, user string) string {
+			return "<s>[INST] " + joinSystem(system, user) + " [/INST]"
+		},
+	},
+	"chatml": {
+		Name: "chatml",
+		render: func(system, user string) string {
+			return "<|im_start|>system\n" + system + "<|im_end|>\n" +
+				"<|im_start|>user\n" + user + "<|im_end|>\n" +
+				"<|im_start|>assistant\n"
+		},
+	},
+	"gemma": {
+		Name: "gemma",
+		// Gemma has no system role either
+		render: func(system, user string) string {
+			return "<bos><start_of_turn>user\n" + joinSystem(system, user) + "<end_of_turn>\n" +
+				"<start_of_turn>model\n"
+		},
+	},
+	"zephyr": {
+		Name: "zephyr",
+		render: func(system, user string) string {
+			return "<|system|>\n" + system + "</s>\n" +
+				"<|user|>\n" + user + "</s>\n" +
+				"<|assistant|>\n"
+		},
+	},
+}
+
+// modelFamilies maps fragments of a lowercased model ID to a template name,
+// checked in order so more specific fragments win. Version fragments end in
+// a delimiter so that, say, "codellama-34b" is not taken for Llama 3.
+var modelFamilies = []struct {
+	fragment string
+	template string
+}{
+	{"codellama", "mistral"},
+	{"llama-2-", "mistral"},
+	{"llama-3-", "llama3"},
+	{"llama-3.", "llama3"},
+	{"llama3", "llama3"},
+	{"mistral", "mistral"},
+	{"mixtral", "mistral"},
+	{"gemma", "gemma"},
+	{"zephyr", "zephyr"},
+	{"qwen", "chatml"},
+	{"hermes", "chatml"},
+	{"dolphin", "chatml"},
+	{"chatml", "chatml"},
+}
+
+// defaultChatTemplate is used for models no family matches
+const defaultChatTemplate = "zephyr"
+
+// LookupChatTemplate returns the template registered under name.
+func LookupChatTemplate(name string) (ChatTemplate, error) {
+	t, ok := chatTemplates[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return ChatTemplate{}, fmt.Errorf("unknown chat template %q (available: %s)", name, strings.Join(ChatTemplateNames(), ", "))
+	}
+	return t, nil
+}
+
+// ChatTemplateForModel picks the template for a model ID such as
+// "meta-llama/Llama-3.2-3B-Instruct", falling back to zephyr.
+func ChatTemplateForModel(modelID string) ChatTemplate {
+	id := strings.ToLower(modelID)
+	for _, family := range modelFamilies {
+		if strings.Contains(id, family.fragment) {
+			return chatTemplates[family.template]
+		}
+	}
+	return chatTemplates[defaultChatTemplate]
+}
+
+// ChatTemplateNames lists the registered templates in sorted order.
+func ChatTemplateNames() []string {
+	names := make([]string, 0, len(chatTemplates))
+	for name := range chatTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func joinSystem(system, user string) string {
+	if system == "" {
+		return user
+	}
+	return system + "\n\n" + user
+}
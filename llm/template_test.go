@@ -0,0 +1,91 @@
+package llm
+
+import "testing"
+
+func TestChatTemplateRender(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{
+			template: "llama3",
+			want: "<|begin_of_text|>" +
+				"<|start_header_id|>system<|end_header_id|>\n\nBe brief.<|eot_id|>" +
+				"<|start_header_id|>user<|end_header_id|>\n\nSay hi.<|eot_id|>" +
+				"<|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		{
+			template: "mistral",
+			want:     "<s>[INST] Be brief.\n\nSay hi. [/INST]",
+		},
+		{
+			template: "chatml",
+			want:     "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nSay hi.<|im_end|>\n<|im_start|>assistant\n",
+		},
+		{
+			template: "gemma",
+			want:     "<bos><start_of_turn>user\nBe brief.\n\nSay hi.<end_of_turn>\n<start_of_turn>model\n",
+		},
+		{
+			template: "zephyr",
+			want:     "<|system|>\nBe brief.</s>\n<|user|>\nSay hi.</s>\n<|assistant|>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := LookupChatTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tmpl.Render("Be brief.", "Say hi."); got != tt.want {
+				t.Errorf("Render =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatTemplateForModel(t *testing.T) {
+	tests := []struct {
+		modelID string
+		want    string
+	}{
+		{defaultHuggingFaceModel, "llama3"},
+		{"meta-llama/Llama-3.2-3B-Instruct", "llama3"},
+		{"meta-llama/Meta-Llama3-8B-Instruct", "llama3"},
+		{"meta-llama/Meta-Llama-3-8B-Instruct", "llama3"},
+		{"meta-llama/Llama-2-7b-chat-hf", "mistral"},
+		{"codellama/CodeLlama-34b-Instruct-hf", "mistral"},
+		{"meta-llama/CodeLlama-7b-Instruct-hf", "mistral"},
+		{"mistralai/Mistral-7B-Instruct-v0.3", "mistral"},
+		{"mistralai/Mixtral-8x7B-Instruct-v0.1", "mistral"},
+		{"google/gemma-2-9b-it", "gemma"},
+		{"HuggingFaceH4/zephyr-7b-beta", "zephyr"},
+		{"Qwen/Qwen2.5-7B-Instruct", "chatml"},
+		{"NousResearch/Hermes-3-Llama-3.1-8B", "llama3"},
+		{"NousResearch/Nous-Hermes-2-Mistral-7B-DPO", "mistral"},
+		{"cognitivecomputations/dolphin-2.9-phi3", "chatml"},
+		{"tiiuae/falcon-7b-instruct", "zephyr"},
+	}
+
+	for _, tt := range tests {
+		if got := ChatTemplateForModel(tt.modelID).Name; got != tt.want {
+			t.Errorf("ChatTemplateForModel(%q) = %s, want %s", tt.modelID, got, tt.want)
+		}
+	}
+}
+
+func TestLookupChatTemplateUnknown(t *testing.T) {
+	_, err := LookupChatTemplate("alpaca")
+	if err == nil {
+		t.Fatal("LookupChatTemplate accepted an unknown template")
+	}
+	want := `unknown chat template "alpaca" (available: chatml, gemma, llama3, mistral, zephyr)`
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	if tmpl, err := LookupChatTemplate(" ChatML "); err != nil || tmpl.Name != "chatml" {
+		t.Errorf("LookupChatTemplate(\" ChatML \") = %q, %v; want chatml", tmpl.Name, err)
+	}
+}
@@ -0,0 +1,14 @@
+// llm/tokens.go
+package llm
+
+import "unicode/utf8"
+
+// EstimateTokens approximates how many tokens text uses with common BPE
+// tokenizers, at roughly four characters per token for English prose.
+func EstimateTokens(text string) int {
+	n := utf8.RuneCountInString(text)
+	if n == 0 {
+		return 0
+	}
+	return (n + 3) / 4
+}
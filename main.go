@@ -23,6 +23,15 @@ func main() {
 	// Initialize database
 	db.InitDB()
 
+	// Initialize text generation provider
+	api.InitGenerator()
+
+	// Start asynchronous generation workers
+	api.InitJobs()
+
+	// Initialize speech providers
+	api.InitVoices()
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		BodyLimit: 10 * 1024 * 1024, // 10MB limit for voice synthesis
@@ -56,17 +65,41 @@ func setupRoutes(app *fiber.App) {
 	
 	// Dialogue generation endpoint
 	apiGroup.Post("/generate", api.GenerateDialogue)
+	apiGroup.Post("/generate/stream", api.GenerateDialogueStream)
+	apiGroup.Post("/generate/batch", api.GenerateBatch)
 	apiGroup.Post("/save-dialogue", api.SaveDialogue)
 	apiGroup.Get("/saved-dialogues", api.GetSavedDialogues)
+
+	// Dialogue editing endpoints
+	apiGroup.Post("/dialogues/regenerate", api.RegenerateLine)
+	apiGroup.Post("/dialogues/:id/regenerate", api.RegenerateSavedLine)
+	apiGroup.Post("/dialogues/continue", api.ContinueDialogue)
+	apiGroup.Post("/dialogues/:id/continue", api.ContinueSavedDialogue)
+
+	// Asynchronous generation jobs
+	apiGroup.Post("/jobs", api.CreateJob)
+	apiGroup.Get("/jobs/:id", api.GetJob)
+	apiGroup.Delete("/jobs/:id", api.CancelJob)
 	
 	// Character endpoints
 	apiGroup.Get("/characters", api.GetCharacters)
 	apiGroup.Post("/characters", api.CreateCharacter)
+	apiGroup.Put("/characters/:id/voice", api.SetCharacterVoice)
 	
 	// Reference dialogue endpoints
 	apiGroup.Get("/references", api.GetReferenceDialogues)
 	apiGroup.Post("/references", api.AddReferenceDialogue)
+	apiGroup.Get("/references/search", api.SearchReferenceDialogues)
+
+	// Usage accounting
+	apiGroup.Get("/usage", api.GetUsage)
 	
 	// Voice synthesis endpoint (bonus feature)
 	apiGroup.Post("/synthesize", api.SynthesizeVoice)
+	apiGroup.Post("/dialogues/render", api.RenderDialogue)
+	apiGroup.Post("/dialogues/:id/render", api.RenderSavedDialogue)
+	apiGroup.Post("/dialogues/subtitles", api.ExportSubtitles)
+	apiGroup.Get("/dialogues/:id/subtitles", api.ExportSavedSubtitles)
+	apiGroup.Get("/voices/defaults", api.GetVoiceDefaults)
+	apiGroup.Put("/voices/defaults/:type", api.SetVoiceDefault)
 }
\ No newline at end of file
@@ -0,0 +1,140 @@
+// search/bm25.go
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 tuning constants, using the usual defaults
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Document is a piece of text to index under an ID.
+type Document struct {
+	ID   int
+	Text string
+}
+
+// Result is a matching document and its BM25 score.
+type Result struct {
+	ID    int     `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Index is an in-memory BM25 index. It is immutable once built, so it is safe
+// for concurrent searches; rebuild it to pick up new documents.
+type Index struct {
+	postings  map[string]map[int]int // term -> document ID -> term frequency
+	lengths   map[int]int
+	avgLength float64
+}
+
+// NewIndex builds an index over docs.
+func NewIndex(docs []Document) *Index {
+	ix := &Index{
+		postings: make(map[string]map[int]int),
+		lengths:  make(map[int]int, len(docs)),
+	}
+
+	total := 0
+	for _, doc := range docs {
+		terms := Tokenize(doc.Text)
+		ix.lengths[doc.ID] = len(terms)
+		total += len(terms)
+
+		for _, term := range terms {
+			if ix.postings[term] == nil {
+				ix.postings[term] = make(map[int]int)
+			}
+			ix.postings[term][doc.ID]++
+		}
+	}
+	if len(docs) > 0 {
+		ix.avgLength = float64(total) / float64(len(docs))
+	}
+
+	return ix
+}
+
+// Len reports the number of indexed documents.
+func (ix *Index) Len() int {
+	return len(ix.lengths)
+}
+
+// Search returns documents matching query, best first. A limit of zero or
+// less returns every match.
+func (ix *Index) Search(query string, limit int) []Result {
+	scores := make(map[int]float64)
+	n := float64(len(ix.lengths))
+
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := ix.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for id, tf := range postings {
+			freq := float64(tf)
+			norm := 1 - b + b*float64(ix.lengths[id])/ix.avgLength
+			scores[id] += idf * freq * (k1 + 1) / (freq + k1*norm)
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, Result{ID: id, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "has": true,
+	"have": true, "he": true, "her": true, "his": true, "i": true, "in": true,
+	"is": true, "it": true, "its": true, "me": true, "my": true, "not": true,
+	"of": true, "on": true, "or": true, "she": true, "so": true, "that": true,
+	"the": true, "their": true, "them": true, "they": true, "this": true,
+	"to": true, "was": true, "we": true, "were": true, "what": true,
+	"with": true, "you": true, "your": true,
+}
+
+// Tokenize lowercases text and splits it into indexable terms, dropping
+// punctuation and common English stop words.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+
+	terms := words[:0]
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if len(word) < 2 || stopWords[word] {
+			continue
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
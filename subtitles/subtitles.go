@@ -0,0 +1,188 @@
+// subtitles/subtitles.go
+package subtitles
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats a dialogue can be exported to
+const (
+	FormatSRT = "srt"
+	FormatVTT = "vtt"
+)
+
+// Line is one spoken line and when it is heard.
+type Line struct {
+	Speaker string
+	Text    string
+	Start   time.Duration
+	End     time.Duration
+}
+
+// Cue is one caption as shown on screen.
+type Cue struct {
+	Start   time.Duration
+	End     time.Duration
+	Speaker string
+	// Text holds the cue's rows, wrapped to fit
+	Text []string
+}
+
+// Options limit how much text a cue shows. Zero values use the usual broadcast
+// limits of 42 characters per row and two rows per cue.
+type Options struct {
+	MaxLineLength int
+	MaxRows       int
+}
+
+// minEstimate is the shortest time a line is estimated to take, so short
+// interjections stay on screen long enough to read
+const minEstimate = 700 * time.Millisecond
+
+// Estimate times lines as if spoken one after another at wpm words per minute,
+// with gap between them.
+func Estimate(lines []Line, wpm int, gap time.Duration) []Line {
+	timed := make([]Line, len(lines))
+	var at time.Duration
+	for i, line := range lines {
+		if i > 0 {
+			at += gap
+		}
+		words := len(strings.Fields(line.Text))
+		d := max(time.Duration(words)*time.Minute/time.Duration(wpm), minEstimate)
+
+		line.Start, line.End = at, at+d
+		timed[i] = line
+		at += d
+	}
+	return timed
+}
+
+// Cues wraps each line into rows of at most MaxLineLength characters, counting
+// the speaker label on each cue's first row, and splits lines with more than
+// MaxRows rows over several cues. A split line's time is shared out by length.
+func Cues(lines []Line, opts Options) []Cue {
+	if opts.MaxLineLength <= 0 {
+		opts.MaxLineLength = 42
+	}
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = 2
+	}
+
+	var cues []Cue
+	for _, line := range lines {
+		label := 0
+		if line.Speaker != "" {
+			label = len([]rune(line.Speaker)) + 2 // "Name: "
+		}
+		rows := wrap(line.Text, opts.MaxLineLength, label, opts.MaxRows)
+		if len(rows) == 0 {
+			continue
+		}
+
+		total := 0
+		for _, row := range rows {
+			total += len([]rune(row))
+		}
+
+		start, done := line.Start, 0
+		for i := 0; i < len(rows); i += opts.MaxRows {
+			chunk := rows[i:min(i+opts.MaxRows, len(rows))]
+			for _, row := range chunk {
+				done += len([]rune(row))
+			}
+			end := line.Start + (line.End-line.Start)*time.Duration(done)/time.Duration(total)
+
+			cues = append(cues, Cue{Start: start, End: end, Speaker: line.Speaker, Text: chunk})
+			start = end
+		}
+	}
+	return cues
+}
+
+// wrap breaks text into rows of at most width characters at spaces, leaving
+// room for a label of the given length on the first row of every cue of
+// perCue rows. Words longer than a row are broken.
+func wrap(text string, width, label, perCue int) []string {
+	var rows []string
+	var row []rune
+	limit := func() int {
+		if len(rows)%perCue == 0 {
+			return max(width-label, 1)
+		}
+		return width
+	}
+
+	for _, word := range strings.Fields(text) {
+		w := []rune(word)
+		for len(w) > 0 {
+			switch {
+			case len(row) == 0 && len(w) <= limit():
+				row, w = w, nil
+			case len(row) > 0 && len(row)+1+len(w) <= limit():
+				row = append(append(row, ' '), w...)
+				w = nil
+			case len(row) > 0:
+				rows, row = append(rows, string(row)), nil
+			default:
+				// The word alone is too long for a row
+				n := limit()
+				rows, w = append(rows, string(w[:n])), w[n:]
+			}
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+// WriteSRT writes cues as a SubRip file, with the speaker's name leading the
+// first row.
+func WriteSRT(w io.Writer, cues []Cue) error {
+	for i, cue := range cues {
+		rows := append([]string(nil), cue.Text...)
+		if cue.Speaker != "" {
+			rows[0] = cue.Speaker + ": " + rows[0]
+		}
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			timestamp(cue.Start, ','), timestamp(cue.End, ','), strings.Join(rows, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteVTT writes cues as a WebVTT file, naming the speaker in a voice tag.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for i, cue := range cues {
+		rows := make([]string, len(cue.Text))
+		for j, row := range cue.Text {
+			rows[j] = vttEscaper.Replace(row)
+		}
+		if cue.Speaker != "" {
+			rows[0] = fmt.Sprintf("<v %s>%s", vttEscaper.Replace(cue.Speaker), rows[0])
+		}
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			timestamp(cue.Start, '.'), timestamp(cue.End, '.'), strings.Join(rows, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// timestamp formats d as HH:MM:SS followed by sep and milliseconds.
+func timestamp(d time.Duration, sep byte) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
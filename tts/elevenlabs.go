@@ -0,0 +1,125 @@
+// tts/elevenlabs.go
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defaultElevenLabsModel = "eleven_monolingual_v1"
+
+// elevenLabsPCMRate is the sample rate asked for when a WAV is wanted
+const elevenLabsPCMRate = 22050
+
+type elevenLabsRequest struct {
+	Text          string                  `json:"text"`
+	ModelID       string                  `json:"model_id"`
+	VoiceSettings elevenLabsVoiceSettings `json:"voice_settings"`
+}
+
+type elevenLabsVoiceSettings struct {
+	Stability       float64 `json:"stability"`
+	SimilarityBoost float64 `json:"similarity_boost"`
+	Style           float64 `json:"style,omitempty"`
+	Speed           float64 `json:"speed,omitempty"`
+}
+
+// ElevenLabs talks to the ElevenLabs text-to-speech API. It has no pitch
+// control, so VoiceSettings.Pitch is ignored.
+type ElevenLabs struct {
+	APIKey  string
+	ModelID string
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewElevenLabsFromEnv configures an ElevenLabs provider from
+// ELEVENLABS_API_KEY and ELEVENLABS_MODEL_ID.
+func NewElevenLabsFromEnv() *ElevenLabs {
+	modelID := os.Getenv("ELEVENLABS_MODEL_ID")
+	if modelID == "" {
+		modelID = defaultElevenLabsModel
+	}
+
+	return &ElevenLabs{
+		APIKey:  os.Getenv("ELEVENLABS_API_KEY"),
+		ModelID: modelID,
+		BaseURL: "https://api.elevenlabs.io/v1",
+		Client: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+func (e *ElevenLabs) Name() string {
+	return "elevenlabs"
+}
+
+// DefaultVoice returns "": ElevenLabs voices are picked by ID, so every
+// character needs one assigned.
+func (e *ElevenLabs) DefaultVoice(character string) string {
+	return ""
+}
+
+func (e *ElevenLabs) Synthesize(ctx context.Context, req Request) (*Audio, error) {
+	if e.APIKey == "" {
+		return nil, errors.New("ELEVENLABS_API_KEY environment variable not set")
+	}
+
+	jsonData, err := json.Marshal(elevenLabsRequest{
+		Text:    req.Text,
+		ModelID: e.ModelID,
+		VoiceSettings: elevenLabsVoiceSettings{
+			Stability:       req.Settings.Stability,
+			SimilarityBoost: req.Settings.SimilarityBoost,
+			Style:           req.Settings.Style,
+			Speed:           req.Settings.Speed,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/text-to-speech/%s", e.BaseURL, req.VoiceID)
+	if req.Format == FormatWAV {
+		// Raw little-endian 16-bit PCM, wrapped in a WAV header below
+		url += fmt.Sprintf("?output_format=pcm_%d", elevenLabsPCMRate)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("xi-api-key", e.APIKey)
+
+	resp, err := e.Client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ElevenLabs API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ElevenLabs API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	if req.Format == FormatWAV {
+		samples := make([]int16, len(body)/2)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(body[2*i:]))
+		}
+		return &Audio{Data: EncodeWAV(samples, elevenLabsPCMRate), ContentType: "audio/wav", Extension: "wav"}, nil
+	}
+	return &Audio{Data: body, ContentType: "audio/mpeg", Extension: "mp3"}, nil
+}
@@ -0,0 +1,79 @@
+// tts/provider.go
+package tts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Provider turns text into speech. Voices are named by provider-specific IDs,
+// and audio comes back in the provider's own encoding unless the request asks
+// for WAV, which every provider must be able to return for mixing.
+type Provider interface {
+	Name() string
+	// DefaultVoice picks a voice for a character when nothing else names one,
+	// or returns "" if the provider has no voice of its own to offer
+	DefaultVoice(character string) string
+	Synthesize(ctx context.Context, req Request) (*Audio, error)
+}
+
+// Audio formats a Request may ask for
+const (
+	// FormatNative is whatever the provider produces best, such as MP3
+	FormatNative = ""
+	// FormatWAV is 16-bit mono PCM in a WAV container, ready for mixing
+	FormatWAV = "wav"
+)
+
+// Request is a vendor-neutral synthesis request.
+type Request struct {
+	Text     string
+	VoiceID  string
+	Settings VoiceSettings
+	Format   string
+}
+
+// VoiceSettings shape the delivery. Stability and SimilarityBoost default to
+// 0.75; Style adds expressiveness. All range from 0 to 1. Speed multiplies
+// the rate of speech (0 means normal) and Pitch shifts the voice in
+// semitones; providers that cannot honor them ignore them.
+type VoiceSettings struct {
+	Stability       float64
+	SimilarityBoost float64
+	Style           float64
+	Speed           float64
+	Pitch           float64
+}
+
+// Audio is synthesized speech.
+type Audio struct {
+	Data        []byte
+	ContentType string
+	// Extension is the usual file extension for ContentType, without the dot
+	Extension string
+}
+
+// NewFromEnv returns every available provider by name, and the name of the
+// default one from TTS_PROVIDER: "elevenlabs" (default) or "tone" for the
+// offline stand-in.
+func NewFromEnv() (map[string]Provider, string, error) {
+	tone, err := NewToneFromEnv()
+	if err != nil {
+		return nil, "", err
+	}
+	providers := map[string]Provider{
+		"elevenlabs": NewElevenLabsFromEnv(),
+		"tone":       tone,
+	}
+
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("TTS_PROVIDER")))
+	if name == "" {
+		name = "elevenlabs"
+	}
+	if _, ok := providers[name]; !ok {
+		return nil, "", fmt.Errorf("unknown TTS_PROVIDER %q", name)
+	}
+	return providers, name, nil
+}
@@ -0,0 +1,109 @@
+// tts/tone.go
+package tts
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Tone is an offline provider that renders a WAV of beeps, one per word, or
+// silence of the same length, so voice features can run and be tested without
+// a speech service. The output depends only on the request, and is always a
+// WAV whatever the requested format.
+type Tone struct {
+	SampleRate int
+	// WordsPerMinute sets how long a line lasts
+	WordsPerMinute int
+	// Silent renders silence instead of tones
+	Silent bool
+}
+
+// NewToneFromEnv configures a tone provider. TTS_TONE_WPM sets the speaking
+// rate (default 150) and TTS_TONE_SILENT renders silence instead of tones.
+func NewToneFromEnv() (*Tone, error) {
+	t := &Tone{SampleRate: 22050, WordsPerMinute: 150}
+
+	if v := os.Getenv("TTS_TONE_WPM"); v != "" {
+		wpm, err := strconv.Atoi(v)
+		if err != nil || wpm < 1 {
+			return nil, fmt.Errorf("invalid TTS_TONE_WPM %q", v)
+		}
+		t.WordsPerMinute = wpm
+	}
+	if v := os.Getenv("TTS_TONE_SILENT"); v != "" {
+		silent, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTS_TONE_SILENT %q", v)
+		}
+		t.Silent = silent
+	}
+
+	return t, nil
+}
+
+func (t *Tone) Name() string {
+	return "tone"
+}
+
+// DefaultVoice uses the character itself as the voice, so every character
+// gets its own pitch.
+func (t *Tone) DefaultVoice(character string) string {
+	return character
+}
+
+func (t *Tone) Synthesize(ctx context.Context, req Request) (*Audio, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	speed := req.Settings.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	words := strings.Fields(req.Text)
+	wordSamples := int(float64(t.SampleRate*60) / (float64(t.WordsPerMinute) * speed))
+	// Each word is a beep followed by a short pause
+	beep := wordSamples * 3 / 4
+	samples := make([]int16, len(words)*wordSamples)
+	if t.Silent {
+		return t.audio(samples), nil
+	}
+
+	// The voice picks the pitch, between 180 and 420 Hz before any shift, and
+	// stability how much it wanders from word to word
+	h := fnv.New32a()
+	h.Write([]byte(req.VoiceID))
+	base := (180 + float64(h.Sum32()%240)) * math.Pow(2, req.Settings.Pitch/12)
+	wander := 1 - req.Settings.Stability
+
+	for i, word := range words {
+		h.Reset()
+		h.Write([]byte(word))
+		offset := float64(h.Sum32()%100)/100 - 0.5
+		freq := base * (1 + 0.25*wander*offset)
+
+		start := i * wordSamples
+		for j := 0; j < beep; j++ {
+			// Fade in and out over 5ms to avoid clicks
+			fade := math.Min(1, float64(min(j, beep-j))/(float64(t.SampleRate)*0.005))
+			v := math.Sin(2*math.Pi*freq*float64(j)/float64(t.SampleRate)) * fade * 0.3
+			samples[start+j] = int16(v * math.MaxInt16)
+		}
+	}
+
+	return t.audio(samples), nil
+}
+
+func (t *Tone) audio(samples []int16) *Audio {
+	return &Audio{
+		Data:        EncodeWAV(samples, t.SampleRate),
+		ContentType: "audio/wav",
+		Extension:   "wav",
+	}
+}
@@ -0,0 +1,105 @@
+// tts/wav.go
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EncodeWAV wraps 16-bit mono PCM samples in a WAV container.
+func EncodeWAV(samples []int16, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+
+	var buf bytes.Buffer
+	buf.Grow(44 + dataSize)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))           // chunk size
+	binary.Write(&buf, binary.LittleEndian, uint16(1))            // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1))            // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))   // sample rate
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2)) // byte rate
+	binary.Write(&buf, binary.LittleEndian, uint16(2))            // block align
+	binary.Write(&buf, binary.LittleEndian, uint16(16))           // bits per sample
+
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
+
+// DecodeWAV reads the samples of a 16-bit PCM WAV, mixing stereo and other
+// multi-channel audio down to mono.
+func DecodeWAV(data []byte) (samples []int16, sampleRate int, err error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var channels int
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8 : min(pos+8+size, len(data))]
+
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, errors.New("truncated WAV format chunk")
+			}
+			format := binary.LittleEndian.Uint16(body[0:2])
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits := binary.LittleEndian.Uint16(body[14:16])
+			if format != 1 || bits != 16 || channels < 1 {
+				return nil, 0, fmt.Errorf("unsupported WAV encoding (format %d, %d bits, %d channels)", format, bits, channels)
+			}
+			if sampleRate <= 0 {
+				return nil, 0, fmt.Errorf("invalid WAV sample rate %d", sampleRate)
+			}
+		case "data":
+			if channels == 0 {
+				return nil, 0, errors.New("WAV data before format chunk")
+			}
+			frames := len(body) / (2 * channels)
+			samples = make([]int16, frames)
+			for i := range samples {
+				sum := 0
+				for ch := 0; ch < channels; ch++ {
+					sum += int(int16(binary.LittleEndian.Uint16(body[2*(i*channels+ch):])))
+				}
+				samples[i] = int16(sum / channels)
+			}
+			return samples, sampleRate, nil
+		}
+
+		// Chunks are padded to an even size
+		pos += 8 + size + size%2
+	}
+	return nil, 0, errors.New("WAV file has no data chunk")
+}
+
+// Resample converts samples between rates by linear interpolation.
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+
+	out := make([]int16, int(int64(len(samples))*int64(to)/int64(from)))
+	step := float64(from) / float64(to)
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		if j+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = int16(float64(samples[j])*(1-frac) + float64(samples[j+1])*frac)
+	}
+	return out
+}
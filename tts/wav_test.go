@@ -0,0 +1,27 @@
+package tts
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestDecodeWAVRoundTrip(t *testing.T) {
+	samples := []int16{0, 1000, -1000, 32767, -32768}
+	got, rate, err := DecodeWAV(EncodeWAV(samples, 22050))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 22050 || !slices.Equal(got, samples) {
+		t.Errorf("DecodeWAV = %v at %d Hz, want %v at 22050 Hz", got, rate, samples)
+	}
+}
+
+func TestDecodeWAVRejectsZeroSampleRate(t *testing.T) {
+	data := EncodeWAV([]int16{1, 2, 3}, 22050)
+	binary.LittleEndian.PutUint32(data[24:28], 0) // sample rate in the fmt chunk
+
+	if _, _, err := DecodeWAV(data); err == nil {
+		t.Error("DecodeWAV accepted a zero sample rate")
+	}
+}